@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/jobs"
+	"github.com/jeffyfung/flight-info-agg/pkg/report"
+	"github.com/jeffyfung/flight-info-agg/pkg/scheduler"
+	"github.com/labstack/echo/v4"
+)
+
+func AdminTagsHandler(c echo.Context) error {
+	entries, err := dictionary.List(c.Request().Context())
+	if err != nil {
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, model.Response{Payload: entries})
+}
+
+func AdminCreateTagHandler(c echo.Context) error {
+	var req model.TagEntry
+	err := c.Bind(&req)
+	if err != nil {
+		fmt.Printf("Unable to bind request: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	entry, err := dictionary.Create(c.Request().Context(), req)
+	if err != nil {
+		return dictionaryError(err)
+	}
+	return c.JSON(http.StatusCreated, model.Response{Payload: entry})
+}
+
+func AdminUpdateTagHandler(c echo.Context) error {
+	id, err := url.PathUnescape(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var req model.TagEntry
+	err = c.Bind(&req)
+	if err != nil {
+		fmt.Printf("Unable to bind request: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	entry, err := dictionary.Update(c.Request().Context(), id, req)
+	if err != nil {
+		return dictionaryError(err)
+	}
+	return c.JSON(http.StatusOK, model.Response{Payload: entry})
+}
+
+func AdminDeleteTagHandler(c echo.Context) error {
+	id, err := url.PathUnescape(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err = dictionary.Delete(c.Request().Context(), id); err != nil {
+		return dictionaryError(err)
+	}
+	return c.JSON(http.StatusOK, struct{}{})
+}
+
+// asks the scheduler to re-tag the posts tagged with an older version of the dictionaries. The summary of the
+// run, with its first diffs, is the last result of the job, see AdminJobsHandler. Dry runs and re-tagging every
+// post are left to "cron retag"
+func AdminRetagHandler(c echo.Context) error {
+	return triggerJob(c, jobs.RetagJob)
+}
+
+func AdminReportHandler(c echo.Context) error {
+	var req report.Options
+	err := c.Bind(&req)
+	if err != nil {
+		fmt.Printf("Unable to bind request: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	r, err := report.Build(c.Request().Context(), req)
+	if err != nil {
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, model.Response{Payload: r})
+}
+
+// promotes a suggestion of the report to an alias of the entry with the given id
+func AdminPromoteHandler(c echo.Context) error {
+	var req struct {
+		Text string `json:"text"`
+		ID   string `json:"id"`
+	}
+	err := c.Bind(&req)
+	if err != nil {
+		fmt.Printf("Unable to bind request: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	entry, err := dictionary.AddAlias(c.Request().Context(), req.ID, req.Text)
+	if err != nil {
+		return dictionaryError(err)
+	}
+	return c.JSON(http.StatusOK, model.Response{Payload: entry})
+}
+
+func AdminJobsHandler(c echo.Context) error {
+	jobs, err := scheduler.Jobs(c.Request().Context())
+	if err != nil {
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, model.Response{Payload: jobs})
+}
+
+// asks the scheduler to run the job, on its next poll
+func AdminRunJobHandler(c echo.Context) error {
+	name, err := url.PathUnescape(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return triggerJob(c, name)
+}
+
+func triggerJob(c echo.Context, name string) error {
+	job, err := scheduler.Trigger(c.Request().Context(), name)
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, scheduler.ErrRunning):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case err != nil:
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusAccepted, model.Response{Payload: job})
+}
+
+func dictionaryError(err error) error {
+	switch {
+	case errors.Is(err, dictionary.ErrInvalid):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, dictionary.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, dictionary.ErrConflict):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+}
@@ -11,34 +11,89 @@ import (
 	"github.com/jeffyfung/flight-info-agg/config"
 	model "github.com/jeffyfung/flight-info-agg/models"
 	"github.com/jeffyfung/flight-info-agg/pkg/auth"
-	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/health"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
 	"github.com/jeffyfung/flight-info-agg/pkg/notification/telegram"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
 	"github.com/jeffyfung/flight-info-agg/pkg/tags"
 	"github.com/labstack/echo/v4"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// queries including the archive are bounded, as it is kept for good
+const (
+	maxArchivedPosts = 500
+	archivedWindow   = 365 * 24 * time.Hour
 )
 
 type (
 	QueryPostRequest struct {
+		// of publication, either unbounded when zero
 		From      time.Time `json:"from"`
 		To        time.Time `json:"to"`
 		Locations []string  `json:"locations"`
 		Airlines  []string  `json:"airlines"`
+		// also return the posts moved to the archive past their retention, up to maxArchivedPosts of those
+		// published from archivedWindow ago unless From is set
+		IncludeArchived bool `json:"include_archived"`
 	}
 
 	UserQueryPostRequest struct {
 		QueryPostRequest
 		LoadUserSettings bool `json:"load_user_settings"`
 	}
+
+	// tags are stored as codes; labels are returned alongside for display
+	PostWithLabels struct {
+		model.Post
+		LocationLabels []tags.DestWithLabel     `json:"location_labels"`
+		AirlineLabels  []tags.AirlinesWithLabel `json:"airline_labels"`
+	}
 )
 
+func withLabels(posts []model.Post, lang languages.Lang) []PostWithLabels {
+	return collection.Map(posts, func(post model.Post) PostWithLabels {
+		return PostWithLabels{
+			Post:           post,
+			LocationLabels: tags.EnrichLocationsWithLabels(post.Locations, lang),
+			AirlineLabels:  tags.EnrichAirlinesWithLabels(post.Airlines, lang),
+		}
+	})
+}
+
+// the language of labels, from the locale query param or the Accept-Language header. Defaults to TC
+func localeOf(c echo.Context) languages.Lang {
+	if lang, ok := languages.Parse(c.QueryParam("locale")); ok {
+		return lang
+	}
+	lang, _ := languages.Parse(c.Request().Header.Get("Accept-Language"))
+	return lang
+}
+
 func HealthCheckHandler(c echo.Context) error {
 	return c.String(http.StatusOK, "OK")
 }
 
+// SourcesHealthHandler reports the health of the scraped sources, with a 503 when any is unhealthy so
+// that uptime monitors can watch it
+func SourcesHealthHandler(c echo.Context) error {
+	sources, err := health.Status(c.Request().Context())
+	if err != nil {
+		fmt.Println(err.(*errors.Error).ErrorStack())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	status := http.StatusOK
+	for _, source := range sources {
+		if !source.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	return c.JSON(status, model.Response{Payload: sources})
+}
+
 func AuthProviderHandler(c echo.Context) error {
 	// try to get the user without re-authenticating
 	if gothUser, err := gothic.CompleteUserAuth(c.Response(), c.Request()); err == nil {
@@ -63,9 +118,9 @@ func AuthCallbackHandler(c echo.Context) error {
 
 	// when user logs in, if the user is not in the database, create a new user with the information from provider
 	// the callback should return whether the user is new or not
-	dbUser, err := mongoDB.GetById[model.User]("users", gothUser.Provider+"__"+gothUser.Email)
+	dbUser, err := repository.Users.Get(c.Request().Context(), gothUser.Provider+"__"+gothUser.Email)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
+		if errors.Is(err, repository.ErrNotFound) {
 			t := time.Now().UTC()
 			user := model.User{
 				ID:           gothUser.Provider + "__" + gothUser.Email,
@@ -77,7 +132,7 @@ func AuthCallbackHandler(c echo.Context) error {
 				Notification: model.NotificationOff,
 				TelegramUID:  uuid.New().String(),
 			}
-			mongoDB.InsertToCollection[model.User]("users", user)
+			repository.Users.Insert(c.Request().Context(), user)
 			return c.Redirect(http.StatusFound, config.Cfg.UIOrigin+"/profile?new=1")
 		} else {
 			fmt.Println(err.(*errors.Error).ErrorStack())
@@ -86,7 +141,7 @@ func AuthCallbackHandler(c echo.Context) error {
 	} else {
 		// update last login time
 		t := time.Now().UTC()
-		mongoDB.UpdateById("users", dbUser.ID, bson.D{{Key: "$set", Value: bson.D{{Key: "last_login", Value: &t}}}})
+		repository.Users.SetLastLogin(c.Request().Context(), dbUser.ID, t)
 		return c.Redirect(http.StatusFound, config.Cfg.UIOrigin)
 	}
 
@@ -101,14 +156,15 @@ func ProviderLogoutHandler(c echo.Context) error {
 func UserProfileHandler(c echo.Context) error {
 	gothUser := c.Get("gothUser").(goth.User)
 	userID := gothUser.Provider + "__" + gothUser.Email
-	user, err := mongoDB.GetById[model.User]("users", userID)
+	user, err := repository.Users.Get(c.Request().Context(), userID)
 	if err != nil {
 		fmt.Println(err.(*errors.Error).ErrorStack())
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	selectedLocs := tags.EnrichLocationsWithLabels(user.SelectedLocations)
-	selectedAirlines := tags.EnrichAirlinesWithLabels(user.SelectedAirlines)
+	lang := localeOf(c)
+	selectedLocs := tags.EnrichLocationsWithLabels(user.SelectedLocations, lang)
+	selectedAirlines := tags.EnrichAirlinesWithLabels(user.SelectedAirlines, lang)
 
 	var wrappedUser = struct {
 		model.User
@@ -134,13 +190,11 @@ func UpdateUserProfileHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	update := bson.D{{Key: "$set", Value: bson.D{
-		{Key: "last_updated", Value: time.Now().UTC()},
-		{Key: "selected_locations", Value: req.SelectedLocations},
-		{Key: "selected_airlines", Value: req.SelectedAirlines},
-		{Key: "notification", Value: req.Notification},
-	}}}
-	_, err = mongoDB.UpdateById("users", userID, update)
+	query := model.Query{
+		SelectedLocations: tags.ResolveLocations(req.SelectedLocations),
+		SelectedAirlines:  tags.ResolveAirlines(req.SelectedAirlines),
+	}
+	err = repository.Users.SetSubscription(c.Request().Context(), userID, query, req.Notification)
 	if err != nil {
 		fmt.Println(err.(*errors.Error).ErrorStack())
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -157,64 +211,39 @@ func UserQueryPostsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	filter := bson.D{}
+	req.Locations, req.Airlines = tags.ResolveLocations(req.Locations), tags.ResolveAirlines(req.Airlines)
+
 	selectedLocations, selectedAirlines := req.Locations, req.Airlines
 
 	if req.LoadUserSettings {
 		gothUser := c.Get("gothUser").(goth.User)
-		user, err := mongoDB.GetById[model.User]("users", gothUser.Provider+"__"+gothUser.Email)
+		user, err := repository.Users.Get(c.Request().Context(), gothUser.Provider+"__"+gothUser.Email)
 		if err != nil {
 			fmt.Println("Cannot find user in database")
 			fmt.Println(err.(*errors.Error).ErrorStack())
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
-
-		if len(user.SelectedLocations) > 0 {
-			filter = append(filter, bson.E{
-				Key:   "locations",
-				Value: bson.M{"$in": user.SelectedLocations},
-			})
-		}
-		if len(user.SelectedAirlines) > 0 {
-			filter = append(filter, bson.E{
-				Key:   "airlines",
-				Value: bson.M{"$in": user.SelectedAirlines},
-			})
-		}
 		selectedLocations, selectedAirlines = user.SelectedLocations, user.SelectedAirlines
-	} else {
-		if len(req.Locations) > 0 {
-			filter = append(filter, bson.E{
-				Key:   "locations",
-				Value: bson.M{"$in": req.Locations},
-			})
-		}
-		if len(req.Airlines) > 0 {
-			filter = append(filter, bson.E{
-				Key:   "airlines",
-				Value: bson.M{"$in": req.Airlines},
-			})
-		}
 	}
 
-	sort := mongoDB.SortOption{SortKey: "pub_date", Order: -1}
-	posts, err := mongoDB.Find[model.Post]("posts", filter, sort)
+	posts, err := repository.Posts.Find(c.Request().Context(), postFilterOf(selectedLocations, selectedAirlines, req.QueryPostRequest))
 	if err != nil {
 		fmt.Println("Cannot find posts in database")
 		fmt.Println(err.(*errors.Error).ErrorStack())
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	selectedLocationsWithLabel := tags.EnrichLocationsWithLabels(selectedLocations)
-	selectedAirlinesWithLabel := tags.EnrichAirlinesWithLabels(selectedAirlines)
+	lang := localeOf(c)
+	selectedLocationsWithLabel := tags.EnrichLocationsWithLabels(selectedLocations, lang)
+	selectedAirlinesWithLabel := tags.EnrichAirlinesWithLabels(selectedAirlines, lang)
 
 	return c.JSON(http.StatusOK, model.Response{
 		Payload: struct {
-			Posts             []model.Post             `json:"posts"`
+			Posts             []PostWithLabels         `json:"posts"`
 			SelectedLocations []tags.DestWithLabel     `json:"selected_locations"`
 			SelectedAirlines  []tags.AirlinesWithLabel `json:"selected_airlines"`
 		}{
-			Posts:             posts,
+			Posts:             withLabels(posts, lang),
 			SelectedLocations: selectedLocationsWithLabel,
 			SelectedAirlines:  selectedAirlinesWithLabel,
 		},
@@ -228,23 +257,9 @@ func QueryPostsHandler(c echo.Context) error {
 		fmt.Printf("Unable to bind request: %v\n", err.Error())
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
+	req.Locations, req.Airlines = tags.ResolveLocations(req.Locations), tags.ResolveAirlines(req.Airlines)
 
-	filter := bson.D{}
-	if len(req.Locations) > 0 {
-		filter = append(filter, bson.E{
-			Key:   "locations",
-			Value: bson.M{"$in": req.Locations},
-		})
-	}
-	if len(req.Airlines) > 0 {
-		filter = append(filter, bson.E{
-			Key:   "airlines",
-			Value: bson.M{"$in": req.Airlines},
-		})
-	}
-
-	sort := mongoDB.SortOption{SortKey: "pub_date", Order: -1}
-	posts, err := mongoDB.Find[model.Post]("posts", filter, sort)
+	posts, err := repository.Posts.Find(c.Request().Context(), postFilterOf(req.Locations, req.Airlines, req))
 	if err != nil {
 		fmt.Println("Cannot find posts in database")
 		fmt.Println(err.(*errors.Error).ErrorStack())
@@ -253,22 +268,45 @@ func QueryPostsHandler(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, model.Response{
 		Payload: struct {
-			Posts []model.Post `json:"posts"`
+			Posts []PostWithLabels `json:"posts"`
 		}{
-			Posts: posts,
+			Posts: withLabels(posts, localeOf(c)),
 		},
 	})
 }
 
+// postFilterOf matches the places under the locations and the airlines in the groups, published within the dates
+// of the request
+func postFilterOf(locations []string, airlines []string, req QueryPostRequest) repository.PostFilter {
+	filter := repository.PostFilter{PublishedFrom: req.From, PublishedTo: req.To, IncludeArchived: req.IncludeArchived}
+	if req.IncludeArchived {
+		filter.Limit = maxArchivedPosts
+		if filter.PublishedFrom.IsZero() {
+			filter.PublishedFrom = time.Now().UTC().Add(-archivedWindow)
+		}
+	}
+	if len(locations) > 0 {
+		filter.Locations = tags.WithDescendants(locations)
+	}
+	if len(airlines) > 0 {
+		filter.Airlines = tags.ExpandAirlines(airlines)
+	}
+	return filter
+}
+
 func TagsHandler(c echo.Context) error {
-	dests := tags.DestinationsWithLabels()
-	airlines := tags.AirlinesWithLabels()
+	lang := localeOf(c)
+	dests := tags.DestinationsWithLabels(lang)
+	airlines := tags.AirlinesWithLabels(lang)
+	groups := tags.AirlineGroupsWithLabels(lang)
 	return c.JSON(http.StatusOK, model.Response{Payload: struct {
-		Locations []tags.DestWithLabel     `json:"locations"`
-		Airlines  []tags.AirlinesWithLabel `json:"airlines"`
+		Locations     []tags.DestWithLabel         `json:"locations"`
+		Airlines      []tags.AirlinesWithLabel     `json:"airlines"`
+		AirlineGroups []tags.AirlineGroupWithLabel `json:"airline_groups"`
 	}{
-		Locations: dests,
-		Airlines:  airlines,
+		Locations:     dests,
+		Airlines:      airlines,
+		AirlineGroups: groups,
 	}})
 }
 
@@ -286,19 +324,18 @@ func TelegramWebhookHandler(c echo.Context) error {
 	inputText := req.Message.Text
 	if strings.HasPrefix(inputText, "/start") {
 		telegramUID := strings.TrimPrefix(inputText, "/start ")
-		filter := bson.D{{Key: "telegram_uid", Value: telegramUID}}
-		users, err := mongoDB.Find[model.User]("users", filter)
-		if err != nil {
-			fmt.Println(err.(*errors.Error).ErrorStack())
-			message = "Internal server error. Please try again later. If this problem persists, please contact the team"
-		} else if len(users) == 0 {
+		user, err := repository.Users.ByTelegramUID(c.Request().Context(), telegramUID)
+		if errors.Is(err, repository.ErrNotFound) {
 			fmt.Printf("Cannot find user with telegram UID: %v\n", telegramUID)
 			message = "If you are trying to set up notifications, please sign in at our website (852-flight-deals.up.railway.app) and use the profile page to redirect to this bot"
-		} else if users[0].TelegramChatID == 0 {
-			updatedUser := users[0]
+		} else if err != nil {
+			fmt.Println(err.(*errors.Error).ErrorStack())
+			message = "Internal server error. Please try again later. If this problem persists, please contact the team"
+		} else if user.TelegramChatID == 0 {
+			updatedUser := user
 			updatedUser.TelegramChatID = req.Message.Chat.ID
 			updatedUser.Notification = model.NotificationOn
-			mongoDB.ReplaceByID[model.User]("users", updatedUser.ID, updatedUser)
+			repository.Users.Upsert(c.Request().Context(), updatedUser)
 			message = "Welcome to 852 Flight Deals! You have successfully set up notifications. You will now receive news about flight deals and discounts daily. Modify your notification settings (e.g. search filter) using the website: 852-flight-deals.up.railway.app"
 		} else {
 			message = "You have already set up notifications. Modify your notification settings (e.g. search filter) using the website: 852-flight-deals.up.railway.app"
@@ -307,6 +344,6 @@ func TelegramWebhookHandler(c echo.Context) error {
 		message = "This bot sends new posts relating to flight deals and discounts around Hong Kong. Modify your notification settings using the website: \n852-flight-deals.up.railway.app"
 	}
 
-	telegram.NewNotifier().NotifyChat(chatID, message)
+	telegram.NewNotifier().NotifyChat(c.Request().Context(), chatID, message)
 	return c.JSON(http.StatusOK, struct{}{})
 }
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// serve runs the handler over a request with the body as JSON, and decodes the payload of the response into v
+func serve(t *testing.T, handler echo.HandlerFunc, method string, target string, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			return httpErr.Code
+		}
+		t.Fatal(err)
+	}
+	var res struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(res.Payload, v); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code
+}
+
+func TestQueryPostsHandler(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	post := func(title string, days int, locations []string, airlines []string) model.Post {
+		return model.Post{
+			ID:        primitive.NewObjectID(),
+			Title:     title,
+			URL:       "https://example.com/" + title,
+			Source:    model.DataSourceFlyday,
+			PubDate:   now.AddDate(0, 0, -days),
+			CreatedAt: now.AddDate(0, 0, -days),
+			Locations: locations,
+			Airlines:  airlines,
+		}
+	}
+	err := repository.Posts.Insert(ctx, []model.Post{
+		post("tokyo-cathay", 1, []string{"TYO"}, []string{"CX"}),
+		post("osaka-peach", 2, []string{"OSA"}, []string{"MM"}),
+		post("taipei-eva", 3, []string{"TPE"}, []string{"BR"}),
+		post("archived-tokyo", 200, []string{"TYO"}, []string{"CX"}),
+		post("archived-long-ago", 800, []string{"TYO"}, []string{"CX"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repository.Posts.Archive(ctx, repository.ArchiveFilter{PublishedBefore: now.AddDate(0, 0, -100)}, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"all live posts", `{}`, []string{"tokyo-cathay", "osaka-peach", "taipei-eva"}},
+		{"places under a location", `{"locations": ["JP"]}`, []string{"tokyo-cathay", "osaka-peach"}},
+		{"location by name", `{"locations": ["大阪"]}`, []string{"osaka-peach"}},
+		{"airline", `{"airlines": ["CX"]}`, []string{"tokyo-cathay"}},
+		{"location and airline", `{"locations": ["JP"], "airlines": ["MM"]}`, []string{"osaka-peach"}},
+		{"published from", `{"from": "` + now.AddDate(0, 0, -2).Format(time.RFC3339) + `"}`, []string{"tokyo-cathay", "osaka-peach"}},
+		{"published before", `{"to": "` + now.AddDate(0, 0, -2).Format(time.RFC3339) + `"}`, []string{"taipei-eva"}},
+		{"archive within a year", `{"include_archived": true, "locations": ["TYO"]}`, []string{"tokyo-cathay", "archived-tokyo"}},
+		{"archive from a date", `{"include_archived": true, "locations": ["TYO"], "from": "` + now.AddDate(-3, 0, 0).Format(time.RFC3339) + `"}`,
+			[]string{"tokyo-cathay", "archived-tokyo", "archived-long-ago"}},
+	}
+	for _, c := range cases {
+		var payload struct {
+			Posts []PostWithLabels `json:"posts"`
+		}
+		code := serve(t, QueryPostsHandler, http.MethodPost, "/posts", c.body, &payload)
+		got := []string{}
+		for _, p := range payload.Posts {
+			got = append(got, p.Title)
+		}
+		if code != http.StatusOK || !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %d %v, want %v", c.name, code, got, c.want)
+		}
+	}
+
+	if code := serve(t, QueryPostsHandler, http.MethodPost, "/posts", `{"from": "yesterday"}`, &struct{}{}); code != http.StatusBadRequest {
+		t.Errorf("invalid from: got %d, want 400", code)
+	}
+
+	var payload struct {
+		Posts []PostWithLabels `json:"posts"`
+	}
+	serve(t, QueryPostsHandler, http.MethodPost, "/posts?locale=en", `{"airlines": ["CX"]}`, &payload)
+	if len(payload.Posts) != 1 || len(payload.Posts[0].LocationLabels) != 1 || payload.Posts[0].LocationLabels[0].Label != "Tokyo" ||
+		len(payload.Posts[0].AirlineLabels) != 1 || payload.Posts[0].AirlineLabels[0].Value != "CX" {
+		t.Errorf("got %+v, want the post with English labels", payload.Posts)
+	}
+}
+
+func TestTagsHandler(t *testing.T) {
+	cases := []struct {
+		target string
+		header string
+		want   string // label of JP
+	}{
+		{"/tags", "", "日本 Japan"},
+		{"/tags?locale=en", "", "Japan"},
+		{"/tags", "en-GB,en;q=0.9", "Japan"},
+		{"/tags?locale=tc", "en", "日本 Japan"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.target, nil)
+		req.Header.Set("Accept-Language", c.header)
+		rec := httptest.NewRecorder()
+		if err := TagsHandler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		var res struct {
+			Payload struct {
+				Locations     []map[string]any `json:"locations"`
+				Airlines      []map[string]any `json:"airlines"`
+				AirlineGroups []map[string]any `json:"airline_groups"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		i := slices.IndexFunc(res.Payload.Locations, func(l map[string]any) bool { return l["value"] == "JP" })
+		if rec.Code != http.StatusOK || i < 0 || res.Payload.Locations[i]["label"] != c.want {
+			t.Errorf("%s %q: got %d, JP at %d, want labelled %s", c.target, c.header, rec.Code, i, c.want)
+		}
+		if len(res.Payload.Airlines) == 0 || len(res.Payload.AirlineGroups) == 0 {
+			t.Errorf("%s %q: got %d airlines and %d groups, want some", c.target, c.header, len(res.Payload.Airlines), len(res.Payload.AirlineGroups))
+		}
+	}
+}
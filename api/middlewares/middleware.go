@@ -6,7 +6,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	model "github.com/jeffyfung/flight-info-agg/models"
 	"github.com/jeffyfung/flight-info-agg/pkg/auth"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
 	"github.com/labstack/echo/v4"
+	"github.com/markbates/goth"
 )
 
 type Claims struct {
@@ -55,3 +57,18 @@ func UserMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+// AdminMiddleware must run after UserMiddleware
+func AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		gothUser := c.Get("gothUser").(goth.User)
+		user, err := repository.Users.Get(c.Request().Context(), gothUser.Provider+"__"+gothUser.Email)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if user.Role != model.RoleAdmin {
+			return echo.NewHTTPError(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
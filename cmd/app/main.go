@@ -1,38 +1,71 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/jeffyfung/flight-info-agg/api/handlers"
 	"github.com/jeffyfung/flight-info-agg/api/middlewares"
 	"github.com/jeffyfung/flight-info-agg/config"
 	"github.com/jeffyfung/flight-info-agg/pkg/auth"
-	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/jobs"
+	"github.com/jeffyfung/flight-info-agg/pkg/migration"
 	"github.com/jeffyfung/flight-info-agg/pkg/notification/telegram"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/scheduler"
+	"github.com/jeffyfung/flight-info-agg/pkg/scrapper"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 func main() {
 	config.LoadConfig()
+	ctx := context.Background()
 
-	err := mongoDB.InitDB()
+	err := repository.Use(ctx, config.Cfg.Database.StorageURI)
 	if err != nil {
-		log.Fatal("MongDB error: ", err.Error())
+		log.Fatal("Storage error: ", err.Error())
 	}
 	defer func() {
-		err = mongoDB.Disconnect()
+		err = repository.Close()
 		if err != nil {
-			log.Fatal("MongDB error: ", err.Error())
+			log.Fatal("Storage error: ", err.Error())
 		}
 	}()
 
+	err = dictionary.Load(ctx)
+	if err != nil {
+		log.Fatal("Tag dictionary error: ", err.Error())
+	}
+	dictionary.StartRefresh(ctx)
+
+	if config.Cfg.Database.MigrateOnStart {
+		_, err = migration.Run(ctx)
+		if err != nil {
+			log.Fatal("Migration error: ", err.Error())
+		}
+	}
+
 	auth.NewAuth()
-	err = telegram.NewNotifier().SetUp()
+	err = telegram.NewNotifier().SetUp(ctx)
 	if err != nil {
 		log.Fatal("Telegram error: ", err.Error())
 	}
 
+	if config.Cfg.Scheduler.Enabled {
+		err = scrapper.LoadSpecs(ctx)
+		if err != nil {
+			log.Fatal("Source spec error: ", err.Error())
+		}
+		s := scheduler.New(config.Cfg.Scheduler.Jitter)
+		err = jobs.Register(s)
+		if err != nil {
+			log.Fatal("Scheduler error: ", err.Error())
+		}
+		s.Start(ctx)
+	}
+
 	startServer()
 }
 
@@ -49,6 +82,7 @@ func startServer() {
 	}))
 
 	e.GET("/", handlers.HealthCheckHandler)
+	e.GET("/health/sources", handlers.SourcesHealthHandler)
 	e.POST("/webhook/telegram", handlers.TelegramWebhookHandler)
 
 	// login and logout
@@ -66,5 +100,18 @@ func startServer() {
 	user.GET("/profile", handlers.UserProfileHandler)
 	user.POST("/posts", handlers.UserQueryPostsHandler)
 
+	// group of endpoints that require an admin
+	admin := e.Group("/admin", middlewares.UserMiddleware, middlewares.AdminMiddleware)
+
+	admin.GET("/tags", handlers.AdminTagsHandler)
+	admin.POST("/tags", handlers.AdminCreateTagHandler)
+	admin.PUT("/tags/:id", handlers.AdminUpdateTagHandler)
+	admin.DELETE("/tags/:id", handlers.AdminDeleteTagHandler)
+	admin.POST("/retag", handlers.AdminRetagHandler)
+	admin.GET("/report", handlers.AdminReportHandler)
+	admin.POST("/report/promote", handlers.AdminPromoteHandler)
+	admin.GET("/jobs", handlers.AdminJobsHandler)
+	admin.POST("/jobs/:name/run", handlers.AdminRunJobHandler)
+
 	e.Logger.Fatal(e.Start(":" + config.Cfg.Server.Port))
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jeffyfung/flight-info-agg/pkg/matcher"
+)
+
+// benchmarks the alert matcher against synthetic users and posts, without touching the database
+func main() {
+	users := flag.Int("users", 100_000, "number of synthetic users")
+	posts := flag.Int("posts", 200, "number of synthetic new posts")
+	window := flag.Duration("window", time.Minute, "time budget for matching within a cron run")
+	runs := flag.Int("runs", 3, "number of runs")
+	seed := flag.Int64("seed", 1, "random seed")
+	flag.Parse()
+
+	var worst time.Duration
+	for i := 0; i < *runs; i++ {
+		result := matcher.Benchmark(*users, *posts, *seed+int64(i))
+		fmt.Println(result)
+		worst = max(worst, result.BuildTime+result.MatchTime)
+	}
+
+	if worst > *window {
+		fmt.Printf("FAIL: slowest run %v exceeds the %v window\n", worst, *window)
+		os.Exit(1)
+	}
+	fmt.Printf("OK: slowest run %v within the %v window\n", worst, *window)
+}
@@ -1,108 +1,395 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/jeffyfung/flight-info-agg/config"
 	model "github.com/jeffyfung/flight-info-agg/models"
-	"github.com/jeffyfung/flight-info-agg/pkg/collection"
-	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
-	"github.com/jeffyfung/flight-info-agg/pkg/notification/telegram"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/jobs"
+	"github.com/jeffyfung/flight-info-agg/pkg/report"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/retag"
 	"github.com/jeffyfung/flight-info-agg/pkg/scrapper"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/sync/errgroup"
 )
 
+// exit codes of the subcommands. Runs that fail exit with 1, and invalid flags with 2 as the flag package
+// does
+const (
+	exitOK = 0
+	// the run went through, but some sources could not be scrapped or some users could not be notified
+	exitPartial = 3
+)
+
+const usage = `usage: cron [command] [flags]
+
+commands needing neither config nor database:
+  validate-source  check a source spec against a page
+
+commands:
+  run              scrape, delete old posts and notify, as scheduled (default)
+  scrape           scrape the sources and notify of the new posts
+  notify           notify of the posts stored since a date
+  cleanup          archive the posts older than the retention
+  backfill         scrape back to a date, notifying no one
+  retag            re-tag the posts stored
+  report           report posts with missing or weak tags
+
+run "cron <command> -h" for the flags of a command`
+
 func main() {
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	args := []string{}
+	if len(os.Args) > 2 {
+		args = os.Args[2:]
+	}
+	// interrupted runs stop fetching and writing, and leave their leases to be released
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "validate-source":
+		validateSource(ctx, args)
+		return
+	case "", "run", "scrape", "notify", "cleanup", "backfill", "retag", "report":
+	case "-h", "--help", "help":
+		fmt.Println(usage)
+		return
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
 	config.LoadConfig()
 
-	err := mongoDB.InitDB()
+	err := repository.Use(ctx, config.Cfg.Database.StorageURI)
 	if err != nil {
-		log.Fatal("MongDB error: ", err.(*errors.Error).ErrorStack())
+		log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
 	}
-	defer func() {
-		err = mongoDB.Disconnect()
-		if err != nil {
-			log.Fatal("MongDB error: ", err.(*errors.Error).ErrorStack())
-		}
-	}()
 
-	posts, err := scrapper.Scrap()
+	err = dictionary.Load(ctx)
 	if err != nil {
-		log.Fatal("Cron job fails", err.(*errors.Error).ErrorStack())
+		log.Fatal("Tag dictionary error: ", err.(*errors.Error).ErrorStack())
 	}
 
-	result, err := deleteOldPosts(3)
+	code := exitOK
+	switch command {
+	case "", "run":
+		code = run(ctx)
+	case "scrape":
+		code = scrape(ctx, args)
+	case "notify":
+		code = notify(ctx, args)
+	case "cleanup":
+		cleanup(ctx, args)
+	case "backfill":
+		code = backfill(ctx, args)
+	case "retag":
+		retagPosts(ctx, args)
+	case "report":
+		reportTagging(ctx, args)
+	}
+
+	err = repository.Close()
 	if err != nil {
-		log.Fatal("Cannot delete old posts: ", err.(*errors.Error).ErrorStack())
+		log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
 	}
-	fmt.Printf("Delete %d old posts >3 months old\n", result.DeletedCount)
+	os.Exit(code)
+}
 
-	notify(posts)
+// usage: cron [run]
+func run(ctx context.Context) int {
+	report, err := scrapper.Run(ctx, scrapper.Options{})
+	if err != nil {
+		log.Fatal("Cron job fails", err.(*errors.Error).ErrorStack())
+	}
+
+	archived, err := jobs.Cleanup(ctx, 0, false)
+	if err != nil {
+		log.Fatal("Cannot archive old posts: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Printf("Archived %d old posts\n", archived.Total())
 
+	notified, err := jobs.Notify(ctx, report.Posts, jobs.NotifyOptions{})
+	if err != nil {
+		log.Fatal("Cannot notify users: ", err.(*errors.Error).ErrorStack())
+	}
+	return exitCode(report, notified)
 }
 
-func deleteOldPosts(expireMonths int) (result *mongo.DeleteResult, err error) {
-	filter := bson.D{{
-		Key:   "pub_date",
-		Value: bson.M{"$lt": time.Now().UTC().AddDate(0, -1*expireMonths, 0)},
-	}}
-	result, err = mongoDB.DeleteMany("posts", filter)
+// usage: cron scrape [-source flyday,flyagain] [-since 2024-01-31 [-pages 50]] [-dry-run [-user id,...]]
+// with -since, sources are crawled back to the date rather than to their watermark, which are left as they
+// are, and no user is notified of the posts, which may be months old, as by backfill. A dry run parses the
+// posts and matches users, but writes and sends nothing. -user only narrows the alerts of a dry run, as the
+// other users would never be notified of the posts stored otherwise
+func scrape(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	sourceList := fs.String("source", "", "comma-separated sources to scrape, all by default")
+	sinceStr := fs.String("since", "", "crawl back to posts published on this date (YYYY-MM-DD) rather than to the watermarks")
+	pages := fs.Int("pages", 0, "listing pages visited per source at most, 5 by default")
+	userList := fs.String("user", "", "comma-separated users to match on a dry run, e.g. to test their alerts, all by default")
+	dryRun := fs.Bool("dry-run", false, "print the new posts and the alerts rather than write and send them")
+	fs.Parse(args)
+
+	if *userList != "" && !*dryRun {
+		fmt.Fprintln(os.Stderr, "-user needs -dry-run, as the other users would not be notified of the posts stored")
+		os.Exit(2)
+	}
+	opts := scrapper.Options{Sources: sourcesOf(*sourceList), Pages: *pages, DryRun: *dryRun}
+	if *sinceStr != "" {
+		opts.Since = parseDate(fs, "since", *sinceStr)
+	}
+	report, err := scrapper.Run(ctx, opts)
 	if err != nil {
-		return nil, errors.New(err)
+		log.Fatal("Cannot scrape posts: ", err.(*errors.Error).ErrorStack())
 	}
-	return result, nil
+	printScrape(report, *dryRun)
+	if *sinceStr != "" {
+		fmt.Println("Users not notified of posts crawled with -since, see notify")
+		return exitCode(report, jobs.NotifyReport{})
+	}
+
+	notified, err := jobs.Notify(ctx, report.Posts, jobs.NotifyOptions{Users: listOf(*userList), DryRun: *dryRun})
+	if err != nil {
+		log.Fatal("Cannot notify users: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Printf("%d users matched, %d not notified\n", notified.Users, notified.Failed)
+	return exitCode(report, notified)
 }
 
-func notify(posts []model.Post) error {
+// usage: cron notify -since 2024-01-31 [-source flyday] [-user id,...] [-dry-run]
+// users are notified of the posts stored since the date, e.g. after a run failed to send them
+func notify(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	sinceStr := fs.String("since", "", "notify of posts stored since this date (YYYY-MM-DD) or time (RFC 3339)")
+	sourceList := fs.String("source", "", "comma-separated sources of the posts, all by default")
+	userList := fs.String("user", "", "comma-separated users to notify, e.g. to test their alerts, all by default")
+	dryRun := fs.Bool("dry-run", false, "print the alerts rather than send them")
+	fs.Parse(args)
+
+	since := parseDate(fs, "since", *sinceStr)
+	posts, err := repository.Posts.Find(ctx, repository.PostFilter{CreatedSince: since, Sources: sourcesOf(*sourceList)})
+	if err != nil {
+		log.Fatal("Cannot get posts: ", err.(*errors.Error).ErrorStack())
+	}
+
+	notified, err := jobs.Notify(ctx, posts, jobs.NotifyOptions{Users: listOf(*userList), DryRun: *dryRun})
+	if err != nil {
+		log.Fatal("Cannot notify users: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Printf("%d posts since %s, %d users matched, %d not notified\n", len(posts), since.Format(time.RFC3339), notified.Users, notified.Failed)
+	return exitCode(scrapper.Report{}, notified)
+}
 
-	notifier := telegram.NewNotifier()
+// usage: cron cleanup [-retention 3] [-dry-run]
+// posts are moved to the archive. -retention is the months kept of the sources without a retention of
+// their own in FLIGHTAGG_RETENTION_SOURCES
+func cleanup(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	retention := fs.Int("retention", config.Cfg.Retention.Months, "months posts are kept for")
+	dryRun := fs.Bool("dry-run", false, "count the posts to archive rather than move them")
+	fs.Parse(args)
 
-	users, err := mongoDB.Find[model.User]("users", bson.D{})
+	if *retention <= 0 {
+		fmt.Fprintln(os.Stderr, "-retention must be a positive number of months")
+		os.Exit(2)
+	}
+	report, err := jobs.Cleanup(ctx, *retention, *dryRun)
 	if err != nil {
-		return errors.New("Cannot get users" + err.(*errors.Error).ErrorStack())
+		log.Fatal("Cannot archive old posts: ", err.(*errors.Error).ErrorStack())
+	}
+	sources := []string{}
+	for source := range report.Archived {
+		sources = append(sources, string(source))
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		fmt.Printf("  %s: %d\n", source, report.Archived[model.DataSource(source)])
 	}
+	fmt.Printf("Archived %d old posts (dry run: %v)\n", report.Total(), report.DryRun)
+}
 
-	g := new(errgroup.Group)
+// usage: cron validate-source -spec spec.json (-file page.html | -url https://...) [-save]
+// with -save, a spec without problems is stored and run from the next scrape
+func validateSource(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("validate-source", flag.ExitOnError)
+	specPath := fs.String("spec", "", "source spec, as pkg/scrapper/specs/*.json")
+	file := fs.String("file", "", "saved listing page to run the spec over")
+	pageURL := fs.String("url", "", "listing page to fetch and run the spec over, the URL of the spec by default")
+	save := fs.Bool("save", false, "store the spec when it has no problems")
+	fs.Parse(args)
 
-	for _, user := range users {
-		if user.Notification != model.NotificationOn {
-			continue
+	b, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal("Cannot read spec: ", err.Error())
+	}
+	spec, err := scrapper.ParseSpec(b)
+	if err != nil {
+		log.Fatal("Cannot parse spec: ", err.Error())
+	}
+
+	var page []byte
+	if *file != "" {
+		page, err = os.ReadFile(*file)
+		if err != nil {
+			log.Fatal("Cannot read page: ", err.Error())
+		}
+	} else {
+		if *pageURL == "" {
+			*pageURL = spec.URL
 		}
+		config.LoadScrapperConfig()
+		page, err = scrapper.FetchPage(ctx, *pageURL)
+		if err != nil {
+			log.Fatal(err.(*errors.Error).ErrorStack())
+		}
+	}
+
+	check, err := scrapper.CheckSpec(spec, page)
+	if err != nil {
+		log.Fatal(err.(*errors.Error).ErrorStack())
+	}
+	for _, post := range check.Posts {
+		fmt.Printf("  %s %s (%s) locations %v airlines %v\n", post.PubDate.Format(time.DateOnly), post.Title, post.URL, post.Locations, post.Airlines)
+	}
+	if check.Next != "" {
+		fmt.Println("Next page:", check.Next)
+	}
+	for _, problem := range check.Problems {
+		fmt.Println("Problem:", problem)
+	}
+	fmt.Printf("%d posts from %d elements, %d problems\n", len(check.Posts), check.Elements, len(check.Problems))
+	if len(check.Problems) > 0 {
+		os.Exit(1)
+	}
 
-		matchedPosts := newMatchedPost(posts, user)
-		if len(matchedPosts) == 0 {
-			continue
+	if *save {
+		config.LoadConfig()
+		if err = repository.Use(ctx, config.Cfg.Database.StorageURI); err != nil {
+			log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
+		}
+		defer repository.Close()
+		if err = scrapper.SaveSpec(ctx, spec); err != nil {
+			log.Fatal(err.(*errors.Error).ErrorStack())
 		}
+		fmt.Println("Saved spec", spec.Name)
+	}
+}
+
+// usage: cron report [-days 30] [-limit 50] [-min-count 3]
+func reportTagging(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	days := fs.Int("days", 30, "report on posts created in the last days")
+	limit := fs.Int("limit", 50, "number of posts and suggestions listed")
+	minCount := fs.Int("min-count", 3, "posts an n-gram must appear in to be suggested")
+	fs.Parse(args)
 
-		g.Go(func(u model.User) func() error {
-			return func() error {
-				content := notifier.FormatAlertMessages(u, matchedPosts)
-				return notifier.Notify(u, content)
-			}
-		}(user))
+	r, err := report.Build(ctx, report.Options{Days: *days, Limit: *limit, MinCount: *minCount})
+	if err != nil {
+		log.Fatal("Cannot build tagging report: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Printf("Scanned %d posts since %s: %d without locations, %d without airlines, %d tagged by aliases only\n",
+		r.Scanned, r.Since.Format(time.DateOnly), r.NoLocations, r.NoAirlines, r.AliasesOnly)
+	for _, post := range r.Posts {
+		fmt.Printf("  [%s] %s (%s)\n", strings.Join(post.Reasons, ", "), post.Title, post.URL)
 	}
+	fmt.Println("Suggestions:")
+	for _, s := range r.Suggestions {
+		fmt.Printf("  %s: %d posts, e.g. %s\n", s.Text, s.Count, strings.Join(s.Examples, " | "))
+	}
+}
+
+// usage: cron backfill -since 2024-01-31 [-pages 50] [-source flyday,flyagain] [-dry-run]
+// users are not notified of the posts backfilled. Posts older than the retention are archived by the next cleanup
+func backfill(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	sinceStr := fs.String("since", "", "crawl back to posts published on this date (YYYY-MM-DD)")
+	pages := fs.Int("pages", 50, "listing pages visited per source at most")
+	sourceList := fs.String("source", "", "comma-separated sources to backfill, all by default")
+	dryRun := fs.Bool("dry-run", false, "print the posts rather than insert them")
+	fs.Parse(args)
 
-	if err := g.Wait(); err != nil {
-		log.Println("Cannot send email: " + err.(*errors.Error).ErrorStack())
+	since := parseDate(fs, "since", *sinceStr)
+	report, err := scrapper.Run(ctx, scrapper.Options{Sources: sourcesOf(*sourceList), Since: since, Pages: *pages, DryRun: *dryRun})
+	if err != nil {
+		log.Fatal("Cannot backfill posts: ", err.(*errors.Error).ErrorStack())
 	}
+	printScrape(report, *dryRun)
+	fmt.Printf("Backfilled %d posts published since %s\n", len(report.Posts), since.Format(time.DateOnly))
+	return exitCode(report, jobs.NotifyReport{})
+}
 
-	return nil
+// usage: cron retag [-dry-run] [-all] [-batch 200]
+func retagPosts(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("retag", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report the changes without writing them")
+	all := fs.Bool("all", false, "re-tag posts already tagged with the current dictionaries")
+	batchSize := fs.Int64("batch", 200, "number of posts per batch")
+	fs.Parse(args)
 
+	report, err := retag.Run(ctx, retag.Options{DryRun: *dryRun, All: *all, BatchSize: *batchSize})
+	if err != nil {
+		log.Fatal("Cannot re-tag posts: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Println(report)
 }
 
-func newMatchedPost(posts []model.Post, user model.User) []model.Post {
-	matched := []model.Post{}
-	for _, post := range posts {
-		locationsMatched := len(user.SelectedLocations) == 0 || collection.HaveOverlap[string](post.Locations, user.SelectedLocations)
-		airlinesMatched := len(user.SelectedAirlines) == 0 || collection.HaveOverlap[string](post.Airlines, user.SelectedAirlines)
-		if locationsMatched && airlinesMatched {
-			matched = append(matched, post)
+func printScrape(report scrapper.Report, dryRun bool) {
+	if dryRun {
+		for _, post := range report.Posts {
+			fmt.Printf("  would insert %s %s (%s) locations %v airlines %v\n", post.PubDate.Format(time.DateOnly), post.Title, post.URL, post.Locations, post.Airlines)
 		}
 	}
-	return matched
+	fmt.Printf("%d new posts, failed sources %v, skipped sources %v\n", len(report.Posts), report.Failed, report.Skipped)
+}
+
+func exitCode(report scrapper.Report, notified jobs.NotifyReport) int {
+	if len(report.Failed) > 0 || notified.Failed > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+// parseDate exits as the flag package does when the value is not a date (YYYY-MM-DD) or a time (RFC 3339)
+func parseDate(fs *flag.FlagSet, name string, value string) time.Time {
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	fmt.Fprintf(os.Stderr, "invalid value %q for flag -%s: want YYYY-MM-DD or RFC 3339\n", value, name)
+	fs.Usage()
+	os.Exit(2)
+	return time.Time{}
+}
+
+func listOf(s string) []string {
+	output := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			output = append(output, part)
+		}
+	}
+	return output
+}
+
+func sourcesOf(s string) []model.DataSource {
+	output := []model.DataSource{}
+	for _, name := range listOf(s) {
+		output = append(output, model.DataSource(name))
+	}
+	return output
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/migration"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// usage: migrate [-status]
+func main() {
+	status := flag.Bool("status", false, "list the migrations and when they were applied, applying none")
+	flag.Parse()
+
+	config.LoadConfig()
+	ctx := context.Background()
+
+	err := repository.Use(ctx, config.Cfg.Database.StorageURI)
+	if err != nil {
+		log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
+	}
+	defer func() {
+		err = repository.Close()
+		if err != nil {
+			log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
+		}
+	}()
+
+	if *status {
+		statuses, err := migration.List(ctx)
+		if err != nil {
+			log.Fatal(err.(*errors.Error).ErrorStack())
+		}
+		pending := 0
+		for _, s := range statuses {
+			if s.Applied == nil {
+				pending++
+				fmt.Printf("%3d %-40s pending\n", s.Version, s.Name)
+				continue
+			}
+			fmt.Printf("%3d %-40s applied %s\n", s.Version, s.Name, s.Applied.AppliedAt.Format(time.RFC3339))
+		}
+		fmt.Printf("%d migrations pending\n", pending)
+		return
+	}
+
+	// data migrations resolve tags with the stored dictionaries
+	err = dictionary.Load(ctx)
+	if err != nil {
+		log.Fatal("Tag dictionary error: ", err.(*errors.Error).ErrorStack())
+	}
+
+	applied, err := migration.Run(ctx)
+	if err != nil {
+		log.Fatal("Cannot migrate: ", err.(*errors.Error).ErrorStack())
+	}
+	fmt.Printf("Applied %d migrations\n", len(applied))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/jobs"
+	"github.com/jeffyfung/flight-info-agg/pkg/migration"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/scheduler"
+	"github.com/jeffyfung/flight-info-agg/pkg/scrapper"
+)
+
+// the worker runs the scheduled jobs, in place of the cron process, until it is interrupted
+func main() {
+	config.LoadConfig()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := repository.Use(ctx, config.Cfg.Database.StorageURI)
+	if err != nil {
+		log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
+	}
+	defer func() {
+		err = repository.Close()
+		if err != nil {
+			log.Fatal("Storage error: ", err.(*errors.Error).ErrorStack())
+		}
+	}()
+
+	err = dictionary.Load(ctx)
+	if err != nil {
+		log.Fatal("Tag dictionary error: ", err.(*errors.Error).ErrorStack())
+	}
+	dictionary.StartRefresh(ctx)
+
+	if config.Cfg.Database.MigrateOnStart {
+		_, err = migration.Run(ctx)
+		if err != nil {
+			log.Fatal("Migration error: ", err.(*errors.Error).ErrorStack())
+		}
+	}
+
+	err = scrapper.LoadSpecs(ctx)
+	if err != nil {
+		log.Fatal("Source spec error: ", err.(*errors.Error).ErrorStack())
+	}
+
+	s := scheduler.New(config.Cfg.Scheduler.Jitter)
+	err = jobs.Register(s)
+	if err != nil {
+		log.Fatal("Scheduler error: ", err.(*errors.Error).ErrorStack())
+	}
+	s.Start(ctx)
+
+	<-ctx.Done()
+	log.Println("Stopping the scheduler, waiting for the jobs running to be cancelled")
+	s.Stop()
+}
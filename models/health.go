@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	// SourceRun records how a scrape of a source went
+	SourceRun struct {
+		ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		Source     DataSource         `bson:"source" json:"source"`
+		At         time.Time          `bson:"at" json:"at"`
+		StatusCode int                `bson:"status_code" json:"status_code"`
+		FetchError string             `bson:"fetch_error,omitempty" json:"fetch_error,omitempty"`
+		Elements   int                `bson:"elements" json:"elements"` // matched by the post selector on the first page
+		Failures   int                `bson:"failures" json:"failures"` // elements that could not be parsed
+		Pages      int                `bson:"pages" json:"pages"`       // listing pages visited
+		NewPosts   int                `bson:"new_posts" json:"new_posts"`
+	}
+
+	SourceHealth struct {
+		Source      DataSource `bson:"_id" json:"source"`
+		Healthy     bool       `bson:"healthy" json:"healthy"`
+		Problems    []string   `bson:"problems" json:"problems"`
+		LastRun     SourceRun  `bson:"last_run" json:"last_run"`
+		LastHealthy *time.Time `bson:"last_healthy,omitempty" json:"last_healthy,omitempty"`
+	}
+)
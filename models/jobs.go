@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// JobState is the schedule and the last run of a job of pkg/scheduler
+type JobState struct {
+	Name         string     `bson:"_id" json:"name"`
+	Schedule     string     `bson:"schedule" json:"schedule"`
+	LastRun      *time.Time `bson:"last_run,omitempty" json:"last_run,omitempty"`
+	LastDuration int64      `bson:"last_duration_ms" json:"last_duration_ms"`
+	LastError    string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	// what the last run reported, see scheduler.SetResult
+	LastResult string     `bson:"last_result,omitempty" json:"last_result,omitempty"`
+	NextRun    *time.Time `bson:"next_run,omitempty" json:"next_run,omitempty"`
+	// the last time the job was scheduled at that an instance claimed to run it
+	ClaimedSlot *time.Time `bson:"claimed_slot,omitempty" json:"claimed_slot,omitempty"`
+	// asked for from the admin endpoints, run by the scheduler on its next poll
+	TriggeredAt *time.Time `bson:"triggered_at,omitempty" json:"triggered_at,omitempty"`
+	// read from the lease of the job held by the instance running it, rather than stored, so that a run that
+	// crashed is not left running
+	Running      bool       `bson:"-" json:"running"`
+	RunningSince *time.Time `bson:"-" json:"running_since,omitempty"`
+	RunningOn    string     `bson:"-" json:"running_on,omitempty"`
+}
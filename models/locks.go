@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Lease is a lock of pkg/lock, held until it expires unless its holder extends it
+type Lease struct {
+	Name       string    `bson:"_id" json:"name"`
+	Holder     string    `bson:"holder" json:"holder"` // host and process of the holder
+	AcquiredAt time.Time `bson:"acquired_at" json:"acquired_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+}
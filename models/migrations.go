@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// AppliedMigration records a migration of pkg/migration, so that it is run once
+type AppliedMigration struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+	Duration  int64     `bson:"duration_ms" json:"duration_ms"`
+}
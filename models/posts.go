@@ -1,16 +1,32 @@
 package model
 
-import "time"
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Post struct {
-	Title     string     `bson:"title" json:"title"`
-	Summary   string     `bson:"summary" json:"summary"`
-	Locations []string   `bson:"locations" json:"locations"`
-	Airlines  []string   `bson:"airlines" json:"airlines"`
-	URL       string     `bson:"url" json:"url"`
-	PubDate   time.Time  `bson:"pub_date" json:"pub_date"`
-	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
-	Source    DataSource `bson:"source" json:"source"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title     string             `bson:"title" json:"title"`
+	Summary   string             `bson:"summary" json:"summary"`
+	Locations []string           `bson:"locations" json:"locations"`
+	Airlines  []string           `bson:"airlines" json:"airlines"`
+	Matches   []TagMatch         `bson:"matches,omitempty" json:"matches"`
+	// version of the tag dictionaries the post was tagged with
+	DictVersion int64      `bson:"dict_version" json:"dict_version"`
+	URL         string     `bson:"url" json:"url"`
+	PubDate     time.Time  `bson:"pub_date" json:"pub_date"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	Source      DataSource `bson:"source" json:"source"`
+	// from the article page, when fetched. Summary stays the teaser of the listing
+	Body   string            `bson:"body,omitempty" json:"body,omitempty"`
+	Image  string            `bson:"image,omitempty" json:"image,omitempty"`
+	Author string            `bson:"author,omitempty" json:"author,omitempty"`
+	Fields map[string]string `bson:"fields,omitempty" json:"fields,omitempty"` // e.g. 航點, 價錢
+	// set once the post is moved to the archive, past the retention of its source
+	ArchivedAt *time.Time `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
 }
 
 type DataSource string
@@ -19,3 +35,39 @@ const (
 	DataSourceFlyday   DataSource = "flyday"
 	DataSourceFlyAgain DataSource = "flyagain"
 )
+
+// TagMatch records why a post was tagged: the span of text that matched, where it was found and
+// the tags it resolved to
+type TagMatch struct {
+	Kind   TagKind  `bson:"kind" json:"kind"`
+	Field  string   `bson:"field" json:"field"`
+	Text   string   `bson:"text" json:"text"`
+	Offset int      `bson:"offset" json:"offset"` // in runes, from the start of the field
+	Tags   []string `bson:"tags" json:"tags"`
+	Alias  bool     `bson:"alias" json:"alias"` // true when Text is an alias rather than the tag itself
+}
+
+type TagKind string
+
+const (
+	TagKindLocation TagKind = "location"
+	TagKindAirline  TagKind = "airline"
+)
+
+// fields of a scrapped page that tags can be extracted from
+const (
+	FieldTitle        = "title"
+	FieldSummary      = "summary"
+	FieldCategory     = "category"
+	FieldDestinations = "destinations"
+	FieldAirlines     = "airlines"
+	FieldBody         = "body"
+)
+
+// e.g. "台北 → 台灣" for an alias, "台灣" for a direct match
+func (m TagMatch) String() string {
+	if !m.Alias {
+		return m.Text
+	}
+	return m.Text + " → " + strings.Join(m.Tags, ", ")
+}
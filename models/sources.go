@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+type (
+	// SourceSpec describes the listing pages of an HTML source for the generic engine of pkg/scrapper, so
+	// that new sites need no code. Selectors other than Item and Next are relative to a post
+	SourceSpec struct {
+		Name       DataSource      `bson:"_id" json:"name"`
+		URL        string          `bson:"url" json:"url"`   // of the first listing page
+		Item       string          `bson:"item" json:"item"` // selector of the posts listed
+		Title      Selector        `bson:"title" json:"title"`
+		Link       Selector        `bson:"link" json:"link"`
+		Date       Selector        `bson:"date" json:"date"`
+		Summary    *Selector       `bson:"summary,omitempty" json:"summary,omitempty"`
+		Categories *Selector       `bson:"categories,omitempty" json:"categories,omitempty"` // each element is a category
+		Labelled   *LabelledFields `bson:"labelled,omitempty" json:"labelled,omitempty"`
+		Next       *Selector       `bson:"next,omitempty" json:"next,omitempty"` // link to the page of older posts
+		Article    *ArticleSpec    `bson:"article,omitempty" json:"article,omitempty"`
+		DateLayout string          `bson:"date_layout" json:"date_layout"` // as time.Parse takes it
+		Timezone   string          `bson:"timezone,omitempty" json:"timezone,omitempty"`
+		// fields tags are extracted from, among title, summary, category, destinations and airlines. All
+		// by default
+		TagFields []string   `bson:"tag_fields,omitempty" json:"tag_fields,omitempty"`
+		Disabled  bool       `bson:"disabled,omitempty" json:"disabled,omitempty"`
+		UpdatedAt *time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	}
+
+	// Selector picks a value: the text of the elements matched, or the attribute of the first one. An
+	// empty CSS selects the post itself
+	Selector struct {
+		CSS  string `bson:"css" json:"css"`
+		Attr string `bson:"attr,omitempty" json:"attr,omitempty"`
+	}
+
+	// LabelledFields reads posts written as paragraphs of "label：value", e.g. "航點：東京、大阪"
+	LabelledFields struct {
+		Paragraphs string          `bson:"paragraphs" json:"paragraphs"`
+		Label      string          `bson:"label" json:"label"` // selector of the label in a paragraph
+		Fields     []LabelledField `bson:"fields" json:"fields"`
+	}
+
+	// ArticleSpec describes the article page of the posts, fetched when enabled. Selectors are relative to the
+	// page, and its paragraphs of "label：value" are kept as the fields of the post
+	ArticleSpec struct {
+		Body   string    `bson:"body" json:"body"` // selector of the content, whose paragraphs and list items make the body
+		Author *Selector `bson:"author,omitempty" json:"author,omitempty"`
+		Image  *Selector `bson:"image,omitempty" json:"image,omitempty"`
+		// fields tags are extracted from, unless already tagged from the listing. Destinations or airlines
+		Fields []LabelledField `bson:"fields,omitempty" json:"fields,omitempty"`
+	}
+
+	LabelledField struct {
+		Label string `bson:"label" json:"label"` // contained in the label
+		Field string `bson:"field" json:"field"` // destinations, airlines or summary
+	}
+)
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// Watermarks are the times up to which the sources have been scrapped. LastUpdated is the time of the last
+// run, which sources scrapped before watermarks were kept per source start from
+type Watermarks struct {
+	LastUpdated time.Time            `bson:"last_updated" json:"last_updated"`
+	Sources     map[string]time.Time `bson:"sources" json:"sources"`
+}
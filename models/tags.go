@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+type (
+	// TagEntry is a dictionary entry used to tag posts. Places refer to their parent by code to form the
+	// taxonomy. Destination aliases map a text (stored as the code) to several places
+	TagEntry struct {
+		ID            string        `bson:"_id" json:"id"`
+		Kind          TagEntryKind  `bson:"kind" json:"kind"`
+		Code          string        `bson:"code" json:"code"`
+		NameEN        string        `bson:"name_en,omitempty" json:"name_en,omitempty"`
+		NameTC        string        `bson:"name_tc,omitempty" json:"name_tc,omitempty"`
+		NameSC        string        `bson:"name_sc,omitempty" json:"name_sc,omitempty"` // only when not the TC name converted
+		Parent        string        `bson:"parent,omitempty" json:"parent,omitempty"`
+		Aliases       []string      `bson:"aliases,omitempty" json:"aliases,omitempty"`
+		Targets       []string      `bson:"targets,omitempty" json:"targets,omitempty"`
+		AmbiguousCode bool          `bson:"ambiguous_code,omitempty" json:"ambiguous_code,omitempty"`
+		Rules         []ContextRule `bson:"rules,omitempty" json:"rules,omitempty"`
+		// airline metadata, see the airline groups of pkg/tags
+		Alliance  string     `bson:"alliance,omitempty" json:"alliance,omitempty"`
+		LowCost   bool       `bson:"low_cost,omitempty" json:"low_cost,omitempty"`
+		Country   string     `bson:"country,omitempty" json:"country,omitempty"`
+		UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
+	}
+
+	TagEntryKind string
+
+	// ContextRule only lets an alias match when it is followed by one of the words, e.g. 中華 is China
+	// Airlines in "中華 航空" but not in "中華料理"
+	ContextRule struct {
+		Alias      string   `bson:"alias" json:"alias"`
+		FollowedBy []string `bson:"followed_by" json:"followed_by"`
+		Window     int      `bson:"window,omitempty" json:"window,omitempty"` // runes allowed in between, defaults to 2
+	}
+)
+
+const (
+	TagEntryPlace            TagEntryKind = "place"
+	TagEntryAirline          TagEntryKind = "airline"
+	TagEntryDestinationAlias TagEntryKind = "destination_alias"
+)
+
+func TagEntryID(kind TagEntryKind, code string) string {
+	return string(kind) + ":" + code
+}
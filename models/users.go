@@ -22,6 +22,7 @@ type (
 		Notification   Notification `json:"notification" bson:"notification"`
 		TelegramUID    string       `json:"telegram_uid" bson:"telegram_uid"`
 		TelegramChatID int64        `json:"telegram_chat_id" bson:"telegram_chat_id,omitempty"`
+		Role           Role         `json:"role" bson:"role"`
 	}
 
 	Role int
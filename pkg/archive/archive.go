@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+type (
+	Options struct {
+		// months posts of the sources without a retention of their own are kept, config.Cfg.Retention.Months
+		// by default
+		Months int
+		DryRun bool // count the posts to archive rather than move them
+	}
+
+	Report struct {
+		Archived map[model.DataSource]int64 `json:"archived"`
+		DryRun   bool                       `json:"dry_run"`
+	}
+)
+
+// Run moves the posts published before the retention of their source to the archive, which keeps them for
+// price trends and seasonal analysis
+func Run(ctx context.Context, opts Options) (Report, error) {
+	report := Report{Archived: map[model.DataSource]int64{}, DryRun: opts.DryRun}
+	if opts.Months <= 0 {
+		opts.Months = config.Cfg.Retention.Months
+	}
+	now := time.Now().UTC()
+
+	// the sources with a retention of their own, then every other source
+	names := []model.DataSource{}
+	for name := range config.Cfg.Retention.Sources {
+		names = append(names, model.DataSource(name))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	filters := []repository.ArchiveFilter{}
+	for _, name := range names {
+		filters = append(filters, repository.ArchiveFilter{
+			Sources:         []model.DataSource{name},
+			PublishedBefore: now.AddDate(0, -config.Cfg.Retention.Sources[string(name)], 0),
+		})
+	}
+	filters = append(filters, repository.ArchiveFilter{
+		ExceptSources:   names,
+		PublishedBefore: now.AddDate(0, -opts.Months, 0),
+	})
+
+	for _, filter := range filters {
+		archived, err := repository.Posts.Archive(ctx, filter, now, opts.DryRun)
+		for source, n := range archived {
+			report.Archived[source] += n
+		}
+		if err != nil {
+			return report, errors.New("Cannot archive posts: " + err.Error())
+		}
+	}
+	return report, nil
+}
+
+// Total is the number of posts archived, or to archive on a dry run
+func (r Report) Total() int64 {
+	total := int64(0)
+	for _, n := range r.Archived {
+		total += n
+	}
+	return total
+}
@@ -8,18 +8,17 @@ func Map[T, U any](array []T, f func(T) U) []U {
 	return outputArr
 }
 
+// RemoveListDuplicates keeps the first occurrence of each item, in order
 func RemoveListDuplicates[T comparable](inputList []T) []T {
-	uniqueMap := make(map[T]struct{}) // Using an empty struct as a placeholder value
+	seen := make(map[T]struct{}) // Using an empty struct as a placeholder value
 
-	// Iterate over the input list and add each element to the map
+	uniqueList := make([]T, 0, len(inputList))
 	for _, item := range inputList {
-		uniqueMap[item] = struct{}{}
-	}
-
-	// Extract the unique keys from the map into a new slice
-	uniqueList := make([]T, 0, len(uniqueMap))
-	for key := range uniqueMap {
-		uniqueList = append(uniqueList, key)
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		uniqueList = append(uniqueList, item)
 	}
 
 	return uniqueList
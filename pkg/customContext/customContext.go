@@ -1,5 +0,0 @@
-package customContext
-
-import "context"
-
-var EmptyCtx context.Context = context.Background()
@@ -1,13 +1,13 @@
 package mongoDB
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/jeffyfung/flight-info-agg/config"
 	"github.com/jeffyfung/flight-info-agg/pkg/collection"
-	"github.com/jeffyfung/flight-info-agg/pkg/customContext"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -22,19 +22,21 @@ type (
 
 var db *mongo.Database
 
-func InitDB() error {
+func InitDB(ctx context.Context) error {
 
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 	opts := options.Client().ApplyURI(config.Cfg.Database.MongodbUri).SetServerAPIOptions(serverAPI)
 	opts.SetConnectTimeout(2 * time.Second)
 	opts.SetBSONOptions(&options.BSONOptions{UseJSONStructTags: true})
 
-	client, err := mongo.Connect(customContext.EmptyCtx, opts)
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return errors.New("cannot connect to MongoDB")
 	}
 
-	if err := client.Database("admin").RunCommand(customContext.EmptyCtx, bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
+	pingCtx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err := client.Database("admin").RunCommand(pingCtx, bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
 		return errors.New("Cannot ping MongoDB: " + err.Error())
 	}
 	fmt.Println("Connected to MongoDB")
@@ -44,8 +46,8 @@ func InitDB() error {
 	return nil
 }
 
-func Disconnect() error {
-	if err := db.Client().Disconnect(customContext.EmptyCtx); err != nil {
+func Disconnect(ctx context.Context) error {
+	if err := db.Client().Disconnect(ctx); err != nil {
 		return errors.New("Error disconnecting MongDB" + err.Error())
 	}
 	return nil
@@ -55,66 +57,170 @@ func GetCollection(coll string) *mongo.Collection {
 	return db.Collection(coll)
 }
 
-func GetById[T any](coll string, id string, opts ...*options.FindOneOptions) (T, error) {
+// withTimeout bounds an operation by the configured timeout, on top of the deadline of the caller, e.g. the
+// HTTP request
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.Cfg.Database.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, config.Cfg.Database.Timeout)
+}
+
+func GetById[T any](ctx context.Context, coll string, id string, opts ...*options.FindOneOptions) (T, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	var result T
 	filter := bson.D{{Key: "_id", Value: id}}
-	err := GetCollection(coll).FindOne(customContext.EmptyCtx, filter, opts...).Decode(&result)
+	err := GetCollection(coll).FindOne(ctx, filter, opts...).Decode(&result)
 	if err != nil {
 		return result, errors.New(err)
 	}
 	return result, nil
 }
 
-func InsertToCollection[T any](coll string, doc T, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
-	result, err := GetCollection(coll).InsertOne(customContext.EmptyCtx, doc, opts...)
+func InsertToCollection[T any](ctx context.Context, coll string, doc T, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := GetCollection(coll).InsertOne(ctx, doc, opts...)
 	if err != nil {
 		return nil, errors.New(err)
 	}
 	return result, nil
 }
 
-func InsertBulkToCollection[T any](coll string, docs []T, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+func InsertBulkToCollection[T any](ctx context.Context, coll string, docs []T, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	// to pass type check
 	_docs := collection.Map[T, any](docs, func(doc T) any {
 		return doc
 	})
-	result, err := GetCollection(coll).InsertMany(customContext.EmptyCtx, _docs, opts...)
+	result, err := GetCollection(coll).InsertMany(ctx, _docs, opts...)
 	if err != nil {
 		return nil, errors.New(err)
 	}
 	return result, nil
 }
 
-func UpdateById(coll string, id string, update any, options ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+func UpdateById(ctx context.Context, coll string, id any, update any, options ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	filter := bson.D{{Key: "_id", Value: id}}
-	result, err := GetCollection(coll).UpdateOne(customContext.EmptyCtx, filter, update, options...)
+	result, err := GetCollection(coll).UpdateOne(ctx, filter, update, options...)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return result, nil
+}
+
+// UpdateOne updates the first document matching the filter, e.g. to update a document only if a field
+// is unchanged
+func UpdateOne(ctx context.Context, coll string, filter any, update any, options ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := GetCollection(coll).UpdateOne(ctx, filter, update, options...)
 	if err != nil {
 		return nil, errors.New(err)
 	}
 	return result, nil
 }
 
-func ReplaceByID[T any](coll string, id string, replacement T, options ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+func ReplaceByID[T any](ctx context.Context, coll string, id string, replacement T, options ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	filter := bson.D{{Key: "_id", Value: id}}
-	result, err := GetCollection(coll).ReplaceOne(customContext.EmptyCtx, filter, replacement, options...)
+	result, err := GetCollection(coll).ReplaceOne(ctx, filter, replacement, options...)
 	if err != nil {
 		return nil, errors.New(err)
 	}
 	return result, nil
 }
 
-func Find[T any](coll string, filter any, sorts ...SortOption) (results []T, err error) {
+func Find[T any](ctx context.Context, coll string, filter any, sorts ...SortOption) (results []T, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	sortOptions := collection.Map(sorts, func(sort SortOption) bson.E {
+		return bson.E{Key: sort.SortKey, Value: sort.Order}
+	})
+
+	options := options.Find().SetSort(sortOptions)
+	cursor, err := GetCollection(coll).Find(ctx, filter, options)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if results == nil {
+		results = []T{}
+	}
+	return results, err
+}
+
+// FindPage returns at most limit documents, e.g. to process a collection in batches
+func FindPage[T any](ctx context.Context, coll string, filter any, limit int64, sorts ...SortOption) (results []T, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	sortOptions := collection.Map(sorts, func(sort SortOption) bson.E {
+		return bson.E{Key: sort.SortKey, Value: sort.Order}
+	})
+
+	options := options.Find().SetSort(sortOptions).SetLimit(limit)
+	cursor, err := GetCollection(coll).Find(ctx, filter, options)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if results == nil {
+		results = []T{}
+	}
+	return results, err
+}
+
+// FindEach streams the matching documents through fn with a cursor instead of loading them all in memory. As
+// streams run for as long as fn takes, only the deadline of the caller bounds them
+func FindEach[T any](ctx context.Context, coll string, filter any, fn func(T) error, sorts ...SortOption) error {
 	sortOptions := collection.Map(sorts, func(sort SortOption) bson.E {
 		return bson.E{Key: sort.SortKey, Value: sort.Order}
 	})
 
 	options := options.Find().SetSort(sortOptions)
-	cursor, err := GetCollection(coll).Find(customContext.EmptyCtx, filter, options)
+	cursor, err := GetCollection(coll).Find(ctx, filter, options)
+	if err != nil {
+		return errors.New(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err = cursor.Decode(&doc); err != nil {
+			return errors.New(err)
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return errors.New(err)
+	}
+	return nil
+}
+
+// Aggregate returns the documents output by the pipeline. Stages may spill to disk, and as they take as long
+// as the collection is large, only the deadline of the caller bounds them
+func Aggregate[T any](ctx context.Context, coll string, pipeline any) (results []T, err error) {
+	cursor, err := GetCollection(coll).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
 	if err != nil {
 		return nil, errors.New(err)
 	}
 
-	if err = cursor.All(customContext.EmptyCtx, &results); err != nil {
+	if err = cursor.All(ctx, &results); err != nil {
 		return nil, errors.New(err)
 	}
 
@@ -125,10 +231,22 @@ func Find[T any](coll string, filter any, sorts ...SortOption) (results []T, err
 }
 
 // if filter is an empty bson.D, all documents will be deleted
-func DeleteMany(coll string, filter any) (*mongo.DeleteResult, error) {
-	result, err := GetCollection(coll).DeleteMany(customContext.EmptyCtx, filter)
+func DeleteMany(ctx context.Context, coll string, filter any) (*mongo.DeleteResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := GetCollection(coll).DeleteMany(ctx, filter)
 	if err != nil {
 		return nil, errors.New(err)
 	}
 	return result, nil
 }
+
+// CreateIndexes creates the indexes missing from the collection, leaving the existing ones alone. As index
+// builds take as long as the collection is large, only the deadline of the caller bounds them
+func CreateIndexes(ctx context.Context, coll string, indexes []mongo.IndexModel) ([]string, error) {
+	names, err := GetCollection(coll).Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return names, nil
+}
@@ -0,0 +1,487 @@
+package dictionary
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+const (
+	refreshInterval = time.Minute
+	maxDepth        = 4 // region > country > city > airport
+)
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+var (
+	ErrNotFound = errors.New("tag entry not found")
+	ErrConflict = errors.New("tag entry conflicts with existing entries")
+	ErrInvalid  = errors.New("invalid tag entry")
+)
+
+// Load reads the dictionaries from the database into pkg/tags. They are seeded from the Go tables of
+// pkg/tags the first time. The version is bumped in the system state on every change
+func Load(ctx context.Context) error {
+	version, err := getVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries, err := List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		entries = SeedEntries()
+		if err = seed(ctx, entries); err != nil {
+			return err
+		}
+		fmt.Printf("Seeded %d tag entries\n", len(entries))
+	}
+
+	taxonomy, airlines, destAliases := fromEntries(entries)
+	tags.SetDictionary(version, taxonomy, airlines, destAliases)
+	return nil
+}
+
+// StartRefresh reloads the dictionaries in the background whenever they are changed, e.g. by another instance,
+// until ctx is done
+func StartRefresh(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			version, err := getVersion(ctx)
+			if err != nil {
+				log.Println("Cannot get tag dictionary version: " + err.Error())
+				continue
+			}
+			if version == tags.Version() {
+				continue
+			}
+			if err = Load(ctx); err != nil {
+				log.Println("Cannot reload tag dictionaries: " + err.Error())
+				continue
+			}
+			fmt.Printf("Reloaded tag dictionaries at version %d\n", version)
+		}
+	}()
+}
+
+func List(ctx context.Context) ([]model.TagEntry, error) {
+	return repository.Tags.List(ctx)
+}
+
+func Create(ctx context.Context, entry model.TagEntry) (model.TagEntry, error) {
+	entries, err := List(ctx)
+	if err != nil {
+		return entry, err
+	}
+
+	entry = normalise(entry)
+	if slices.ContainsFunc(entries, func(e model.TagEntry) bool { return e.ID == entry.ID }) {
+		return entry, errors.WrapPrefix(ErrConflict, entry.ID+" already exists", 0)
+	}
+	if err = validate(entry, entries); err != nil {
+		return entry, err
+	}
+
+	if err = repository.Tags.Insert(ctx, entry); err != nil {
+		return entry, err
+	}
+	return entry, changed(ctx)
+}
+
+// Update replaces an entry. The kind and code identify the entry and cannot be changed, as posts and
+// users refer to them
+func Update(ctx context.Context, id string, entry model.TagEntry) (model.TagEntry, error) {
+	entries, err := List(ctx)
+	if err != nil {
+		return entry, err
+	}
+
+	entry = normalise(entry)
+	if entry.ID != id {
+		return entry, errors.WrapPrefix(ErrInvalid, "kind and code cannot be changed", 0)
+	}
+	pos := slices.IndexFunc(entries, func(e model.TagEntry) bool { return e.ID == id })
+	if pos < 0 {
+		return entry, errors.WrapPrefix(ErrNotFound, id, 0)
+	}
+	others := slices.Delete(slices.Clone(entries), pos, pos+1)
+	if err = validate(entry, others); err != nil {
+		return entry, err
+	}
+
+	if err = repository.Tags.Save(ctx, entry); err != nil {
+		return entry, err
+	}
+	return entry, changed(ctx)
+}
+
+func Delete(ctx context.Context, id string) error {
+	entries, err := List(ctx)
+	if err != nil {
+		return err
+	}
+
+	pos := slices.IndexFunc(entries, func(e model.TagEntry) bool { return e.ID == id })
+	if pos < 0 {
+		return errors.WrapPrefix(ErrNotFound, id, 0)
+	}
+	entry := entries[pos]
+	if entry.Kind == model.TagEntryPlace {
+		for _, e := range entries {
+			if e.Kind == model.TagEntryPlace && e.Parent == entry.Code {
+				return errors.WrapPrefix(ErrConflict, e.ID+" is under "+id, 0)
+			}
+			if e.Kind == model.TagEntryDestinationAlias && slices.Contains(e.Targets, entry.Code) {
+				return errors.WrapPrefix(ErrConflict, e.ID+" refers to "+id, 0)
+			}
+		}
+	}
+
+	if err = repository.Tags.Delete(ctx, id); err != nil {
+		return err
+	}
+	return changed(ctx)
+}
+
+// AddAlias adds an alias to a place or an airline, e.g. to promote a suggestion of the tagging report
+func AddAlias(ctx context.Context, id string, alias string) (model.TagEntry, error) {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return model.TagEntry{}, errors.WrapPrefix(ErrInvalid, "alias is required", 0)
+	}
+	if code, ok := tags.LocationCode(alias); ok {
+		return model.TagEntry{}, errors.WrapPrefix(ErrConflict, alias+" already refers to "+code, 0)
+	}
+	if code, ok := tags.AirlineCode(alias); ok {
+		return model.TagEntry{}, errors.WrapPrefix(ErrConflict, alias+" already refers to "+code, 0)
+	}
+
+	entries, err := List(ctx)
+	if err != nil {
+		return model.TagEntry{}, err
+	}
+	pos := slices.IndexFunc(entries, func(e model.TagEntry) bool { return e.ID == id })
+	if pos < 0 {
+		return model.TagEntry{}, errors.WrapPrefix(ErrNotFound, id, 0)
+	}
+	entry := entries[pos]
+	if entry.Kind == model.TagEntryDestinationAlias {
+		return entry, errors.WrapPrefix(ErrInvalid, "destination aliases have no aliases, create one instead", 0)
+	}
+
+	entry.Aliases = append(entry.Aliases, alias)
+	return Update(ctx, id, entry)
+}
+
+// SeedMissing adds the fields introduced after the collection was first seeded, i.e. context rules, airline
+// metadata, ambiguous airline codes and SC names, from the Go tables to stored entries that have none.
+// Otherwise those collections would keep tagging with bare aliases and codes such as QR, have empty airline
+// groups and neither match nor label in SC. Returns the number of entries updated
+func SeedMissing(ctx context.Context) (int, error) {
+	entries, err := List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stored := map[string]model.TagEntry{}
+	for _, e := range entries {
+		stored[e.ID] = e
+	}
+
+	count := 0
+	for _, seeded := range SeedEntries() {
+		entry, ok := stored[seeded.ID]
+		if !ok {
+			continue
+		}
+		entry, changed := withMissingFields(entry, seeded)
+		if !changed {
+			continue
+		}
+		if _, err = Update(ctx, entry.ID, entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// withMissingFields fills the fields of the stored entry that SeedMissing adds from the seeded one, and tells
+// whether any was added
+func withMissingFields(entry model.TagEntry, seeded model.TagEntry) (model.TagEntry, bool) {
+	changed := false
+	for _, rule := range seeded.Rules {
+		ruled := slices.ContainsFunc(entry.Rules, func(r model.ContextRule) bool { return r.Alias == rule.Alias })
+		if slices.Contains(entry.Aliases, rule.Alias) && !ruled {
+			entry.Rules = append(entry.Rules, rule)
+			changed = true
+		}
+	}
+	if entry.NameSC == "" && seeded.NameSC != "" {
+		entry.NameSC = seeded.NameSC
+		changed = true
+	}
+	if seeded.AmbiguousCode && !entry.AmbiguousCode {
+		entry.AmbiguousCode = true
+		changed = true
+	}
+	if entry.Kind == model.TagEntryAirline && entry.Alliance == "" && !entry.LowCost && entry.Country == "" {
+		entry.Alliance, entry.LowCost, entry.Country = seeded.Alliance, seeded.LowCost, seeded.Country
+		changed = changed || seeded.Alliance != "" || seeded.LowCost || seeded.Country != ""
+	}
+	return entry, changed
+}
+
+func normalise(entry model.TagEntry) model.TagEntry {
+	entry.Code = strings.TrimSpace(entry.Code)
+	if entry.Kind != model.TagEntryDestinationAlias {
+		entry.Code = strings.ToUpper(entry.Code)
+	}
+	entry.Parent = strings.ToUpper(strings.TrimSpace(entry.Parent))
+	entry.Alliance = strings.ToUpper(strings.TrimSpace(entry.Alliance))
+	entry.Country = strings.ToUpper(strings.TrimSpace(entry.Country))
+	entry.ID = model.TagEntryID(entry.Kind, entry.Code)
+	t := time.Now().UTC()
+	entry.UpdatedAt = &t
+	return entry
+}
+
+// validates the entry against the other entries of the dictionaries. Aliases taken by another entry, as an
+// alias or a code, are conflicts
+func validate(entry model.TagEntry, others []model.TagEntry) error {
+	places := map[string]model.TagEntry{}
+	for _, e := range others {
+		if e.Kind == model.TagEntryPlace {
+			places[e.Code] = e
+		}
+	}
+
+	if entry.Code == "" {
+		return errors.WrapPrefix(ErrInvalid, "code is required", 0)
+	}
+
+	switch entry.Kind {
+	case model.TagEntryPlace:
+		if entry.NameEN == "" || entry.NameTC == "" {
+			return errors.WrapPrefix(ErrInvalid, "name_en and name_tc are required", 0)
+		}
+		depth := 1
+		for parent := entry.Parent; parent != ""; parent = places[parent].Parent {
+			if parent == entry.Code {
+				return errors.WrapPrefix(ErrInvalid, "a place cannot be under itself", 0)
+			}
+			if _, ok := places[parent]; !ok {
+				return errors.WrapPrefix(ErrInvalid, "unknown parent "+parent, 0)
+			}
+			depth++
+			if depth > maxDepth {
+				return errors.WrapPrefix(ErrInvalid, fmt.Sprintf("places cannot be nested more than %d levels", maxDepth), 0)
+			}
+		}
+	case model.TagEntryAirline:
+		if entry.NameEN == "" || entry.NameTC == "" {
+			return errors.WrapPrefix(ErrInvalid, "name_en and name_tc are required", 0)
+		}
+		if entry.Alliance != "" && !slices.Contains(tags.Alliances, tags.Alliance(entry.Alliance)) {
+			return errors.WrapPrefix(ErrInvalid, "unknown alliance "+entry.Alliance, 0)
+		}
+		if entry.Country != "" && !countryCode.MatchString(entry.Country) {
+			return errors.WrapPrefix(ErrInvalid, "country must be an ISO 3166 code", 0)
+		}
+	case model.TagEntryDestinationAlias:
+		if len(entry.Targets) == 0 {
+			return errors.WrapPrefix(ErrInvalid, "targets are required", 0)
+		}
+		for _, target := range entry.Targets {
+			if _, ok := places[target]; !ok {
+				return errors.WrapPrefix(ErrInvalid, "unknown target "+target, 0)
+			}
+		}
+	default:
+		return errors.WrapPrefix(ErrInvalid, "unknown kind "+string(entry.Kind), 0)
+	}
+
+	// with leftmost-longest matching, one of two entries sharing an alias would silently win
+	owners := map[string]string{}
+	for _, e := range others {
+		if e.ID == entry.ID {
+			continue
+		}
+		owners[languages.Fold(e.Code)] = e.ID
+		for _, alias := range e.Aliases {
+			owners[languages.Fold(alias)] = e.ID
+		}
+	}
+	aliases := entry.Aliases
+	if entry.Kind == model.TagEntryDestinationAlias {
+		aliases = []string{entry.Code}
+	}
+	for _, alias := range aliases {
+		if id, ok := owners[languages.Fold(alias)]; ok {
+			return errors.WrapPrefix(ErrConflict, "alias "+alias+" already belongs to "+id, 0)
+		}
+	}
+
+	for _, rule := range entry.Rules {
+		if !slices.Contains(entry.Aliases, rule.Alias) {
+			return errors.WrapPrefix(ErrInvalid, "rule for unknown alias "+rule.Alias, 0)
+		}
+		if len(rule.FollowedBy) == 0 {
+			return errors.WrapPrefix(ErrInvalid, "rule for "+rule.Alias+" needs followed_by words", 0)
+		}
+	}
+	return nil
+}
+
+// bumps the version so that other instances reload, and reloads this one straight away
+func changed(ctx context.Context) error {
+	if err := repository.System.BumpDictVersion(ctx); err != nil {
+		return errors.New("Cannot update tag dictionary version: " + err.Error())
+	}
+	return Load(ctx)
+}
+
+func getVersion(ctx context.Context) (int64, error) {
+	version, err := repository.System.DictVersion(ctx)
+	if err != nil {
+		return 0, errors.New("Cannot get tag dictionary version: " + err.Error())
+	}
+	return version, nil
+}
+
+func seed(ctx context.Context, entries []model.TagEntry) error {
+	for _, entry := range entries {
+		if err := repository.Tags.Save(ctx, entry); err != nil {
+			return errors.New("Cannot seed tag entries: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// SeedEntries converts the Go tables of pkg/tags to dictionary entries
+func SeedEntries() []model.TagEntry {
+	t := time.Now().UTC()
+	entries := []model.TagEntry{}
+
+	var walk func(places []tags.Place, parent string)
+	walk = func(places []tags.Place, parent string) {
+		for _, place := range places {
+			entries = append(entries, model.TagEntry{
+				ID:        model.TagEntryID(model.TagEntryPlace, place.Code),
+				Kind:      model.TagEntryPlace,
+				Code:      place.Code,
+				NameEN:    place.Names[languages.EN],
+				NameTC:    place.Names[languages.TC],
+				NameSC:    place.Names[languages.SC],
+				Parent:    parent,
+				Aliases:   place.Aliases,
+				Rules:     place.Rules,
+				UpdatedAt: &t,
+			})
+			walk(place.Children, place.Code)
+		}
+	}
+	walk(tags.Taxonomy, "")
+
+	for _, airline := range tags.Airlines {
+		entries = append(entries, model.TagEntry{
+			ID:            model.TagEntryID(model.TagEntryAirline, airline.Code),
+			Kind:          model.TagEntryAirline,
+			Code:          airline.Code,
+			NameEN:        airline.Names[languages.EN],
+			NameTC:        airline.Names[languages.TC],
+			NameSC:        airline.Names[languages.SC],
+			Aliases:       airline.Aliases,
+			AmbiguousCode: airline.AmbiguousCode,
+			Rules:         airline.Rules,
+			Alliance:      string(airline.Alliance),
+			LowCost:       airline.LowCost,
+			Country:       airline.Country,
+			UpdatedAt:     &t,
+		})
+	}
+
+	for alias, targets := range tags.AliasToDestMap {
+		entries = append(entries, model.TagEntry{
+			ID:        model.TagEntryID(model.TagEntryDestinationAlias, alias),
+			Kind:      model.TagEntryDestinationAlias,
+			Code:      alias,
+			Targets:   targets,
+			UpdatedAt: &t,
+		})
+	}
+
+	return entries
+}
+
+func fromEntries(entries []model.TagEntry) ([]tags.Place, []tags.Airline, map[string][]string) {
+	children := map[string][]model.TagEntry{}
+	airlines := []tags.Airline{}
+	destAliases := map[string][]string{}
+
+	for _, e := range entries {
+		switch e.Kind {
+		case model.TagEntryPlace:
+			children[e.Parent] = append(children[e.Parent], e)
+		case model.TagEntryAirline:
+			airlines = append(airlines, tags.Airline{
+				Code:          e.Code,
+				Names:         namesOf(e),
+				Aliases:       e.Aliases,
+				AmbiguousCode: e.AmbiguousCode,
+				Rules:         e.Rules,
+				Alliance:      tags.Alliance(e.Alliance),
+				LowCost:       e.LowCost,
+				Country:       e.Country,
+			})
+		case model.TagEntryDestinationAlias:
+			destAliases[e.Code] = e.Targets
+		}
+	}
+
+	var build func(parent string, depth int) []tags.Place
+	build = func(parent string, depth int) []tags.Place {
+		places := []tags.Place{}
+		if depth > maxDepth {
+			return places
+		}
+		for _, e := range children[parent] {
+			places = append(places, tags.Place{
+				Code:     e.Code,
+				Names:    namesOf(e),
+				Aliases:  e.Aliases,
+				Rules:    e.Rules,
+				Children: build(e.Code, depth+1),
+			})
+		}
+		return places
+	}
+
+	return build("", 1), airlines, destAliases
+}
+
+func namesOf(e model.TagEntry) map[languages.Lang]string {
+	names := map[languages.Lang]string{languages.EN: e.NameEN, languages.TC: e.NameTC}
+	if e.NameSC != "" {
+		names[languages.SC] = e.NameSC
+	}
+	return names
+}
@@ -0,0 +1,83 @@
+package dictionary
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+)
+
+func TestSeedEntriesAreValid(t *testing.T) {
+	entries := SeedEntries()
+	for i, entry := range entries {
+		others := slices.Delete(slices.Clone(entries), i, i+1)
+		if err := validate(normalise(entry), others); err != nil {
+			t.Errorf("%s: %v", entry.ID, err)
+		}
+	}
+}
+
+func TestValidateAliasConflicts(t *testing.T) {
+	entries := SeedEntries()
+	airline := func(code string, aliases ...string) model.TagEntry {
+		return normalise(model.TagEntry{Kind: model.TagEntryAirline, Code: code, NameEN: code, NameTC: code, Aliases: aliases})
+	}
+
+	cases := []struct {
+		name  string
+		entry model.TagEntry
+		want  error
+	}{
+		{"new alias", airline("ZZ", "新航空"), nil},
+		{"alias of another airline", airline("ZZ", "國泰"), ErrConflict},
+		{"alias in another case", airline("ZZ", "ana"), ErrConflict},
+		{"alias equal to a code", airline("ZZ", "CX"), ErrConflict},
+		{"alias equal to a place code", airline("ZZ", "TPE"), ErrConflict},
+		{"alias equal to a destination alias", airline("ZZ", "澳紐"), ErrConflict},
+		{"destination alias equal to an alias", normalise(model.TagEntry{Kind: model.TagEntryDestinationAlias, Code: "國泰",
+			Targets: []string{"JP"}}), ErrConflict},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validate(c.entry, entries)
+			if c.want == nil && err != nil || c.want != nil && !errors.Is(err, c.want) {
+				t.Errorf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+
+	// an entry keeps its own aliases when updated
+	pos := slices.IndexFunc(entries, func(e model.TagEntry) bool { return e.ID == model.TagEntryID(model.TagEntryAirline, "CX") })
+	others := slices.Delete(slices.Clone(entries), pos, pos+1)
+	if err := validate(normalise(entries[pos]), others); err != nil {
+		t.Errorf("update CX: %v", err)
+	}
+}
+
+func TestWithMissingFields(t *testing.T) {
+	pos := slices.IndexFunc(SeedEntries(), func(e model.TagEntry) bool { return e.NameSC != "" && e.NameSC != e.NameTC })
+	if pos < 0 {
+		t.Fatal("no seeded entry with an SC name")
+	}
+	seeded := SeedEntries()[pos]
+
+	// as stored before SC names were seeded
+	stored := seeded
+	stored.NameSC = ""
+	got, changed := withMissingFields(stored, seeded)
+	if !changed || got.NameSC != seeded.NameSC {
+		t.Errorf("got SC name %q (changed %v), want %q", got.NameSC, changed, seeded.NameSC)
+	}
+
+	// names set by an admin are kept
+	stored.NameSC = "自定"
+	got, _ = withMissingFields(stored, seeded)
+	if got.NameSC != "自定" {
+		t.Errorf("got SC name %q, want the stored one", got.NameSC)
+	}
+
+	if _, changed = withMissingFields(seeded, seeded); changed {
+		t.Error("seeded entry changed")
+	}
+}
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/notification/telegram"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+const (
+	trailingRuns    = 7
+	minTrailingRuns = 3   // before drops are looked for
+	maxFailureRatio = 0.2 // of elements that fail to parse
+	minDropRatio    = 0.5 // of the trailing average of elements
+)
+
+// Record stores the run of a source, checks it and updates the health of the source. Maintainers are
+// alerted on Telegram when a source becomes unhealthy and when it recovers
+func Record(ctx context.Context, run model.SourceRun) (model.SourceHealth, error) {
+	previous, err := repository.Health.Runs(ctx, run.Source, trailingRuns)
+	if err != nil {
+		return model.SourceHealth{}, err
+	}
+	if err = repository.Health.InsertRun(ctx, run); err != nil {
+		return model.SourceHealth{}, err
+	}
+
+	old, err := repository.Health.Get(ctx, run.Source)
+	known := err == nil
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return model.SourceHealth{}, err
+	}
+
+	problems := Check(run, previous)
+	health := model.SourceHealth{
+		Source:      run.Source,
+		Healthy:     len(problems) == 0,
+		Problems:    problems,
+		LastRun:     run,
+		LastHealthy: old.LastHealthy,
+	}
+	if health.Healthy {
+		health.LastHealthy = &run.At
+	}
+	if err = repository.Health.Save(ctx, health); err != nil {
+		return health, err
+	}
+
+	// sources are taken as healthy until their first run says otherwise
+	if wasHealthy := !known || old.Healthy; health.Healthy != wasHealthy {
+		alertAdmins(ctx, health)
+	}
+	return health, nil
+}
+
+// Check flags failed fetches, pages where no post element matched although the response was fine (the
+// markup has likely changed), high parse failure ratios and sudden drops of elements against the
+// trailing runs
+func Check(run model.SourceRun, previous []model.SourceRun) []string {
+	problems := []string{}
+	if run.FetchError != "" || run.StatusCode != 200 {
+		return append(problems, fmt.Sprintf("fetch failed with status %d %s", run.StatusCode, run.FetchError))
+	}
+	if run.Elements == 0 {
+		return append(problems, "no post elements matched on a 200 response, the markup may have changed")
+	}
+	if ratio := float64(run.Failures) / float64(run.Elements); ratio > maxFailureRatio {
+		problems = append(problems, fmt.Sprintf("%d of %d post elements failed to parse", run.Failures, run.Elements))
+	}
+
+	total, count := 0, 0
+	for _, r := range previous {
+		// runs where the source was down would drag the average
+		if r.Elements > 0 {
+			total += r.Elements
+			count++
+		}
+	}
+	if count >= minTrailingRuns {
+		average := float64(total) / float64(count)
+		if float64(run.Elements) < minDropRatio*average {
+			problems = append(problems, fmt.Sprintf("%d post elements against a trailing average of %.1f", run.Elements, average))
+		}
+	}
+	return problems
+}
+
+// Status returns the health of every source recorded
+func Status(ctx context.Context) ([]model.SourceHealth, error) {
+	return repository.Health.List(ctx)
+}
+
+func alertAdmins(ctx context.Context, health model.SourceHealth) {
+	var text string
+	if health.Healthy {
+		text = fmt.Sprintf("Source %s has recovered", health.Source)
+	} else {
+		text = fmt.Sprintf("Source %s is unhealthy:\n- %s", health.Source, strings.Join(health.Problems, "\n- "))
+	}
+
+	notifier := telegram.NewNotifier()
+	for _, chatID := range config.Cfg.Telegram.AdminChatIDs {
+		if err := notifier.NotifyChat(ctx, chatID, text); err != nil {
+			log.Println("Cannot alert admin chat", chatID, err.Error())
+		}
+	}
+}
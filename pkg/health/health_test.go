@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+func runOf(elements int, failures int) model.SourceRun {
+	return model.SourceRun{Source: model.DataSourceFlyday, StatusCode: 200, Elements: elements, Failures: failures}
+}
+
+func runsOf(elements ...int) []model.SourceRun {
+	runs := []model.SourceRun{}
+	for _, e := range elements {
+		runs = append(runs, runOf(e, 0))
+	}
+	return runs
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name     string
+		run      model.SourceRun
+		previous []model.SourceRun
+		want     []string // contained in the problems, in order
+	}{
+		{"healthy", runOf(20, 0), runsOf(20, 18, 22), []string{}},
+		{"quiet day, no new posts", model.SourceRun{StatusCode: 200, Elements: 20, NewPosts: 0}, runsOf(20, 20, 20), []string{}},
+		{"first run", runOf(3, 0), nil, []string{}},
+		{"fetch error", model.SourceRun{FetchError: "connection reset"}, runsOf(20, 20, 20), []string{"status 0 connection reset"}},
+		{"not found", model.SourceRun{StatusCode: 404, Elements: 20}, nil, []string{"status 404"}},
+		{"server error", model.SourceRun{StatusCode: 503}, nil, []string{"status 503"}},
+		{"no elements on a 200", runOf(0, 0), runsOf(20, 20, 20), []string{"no post elements matched"}},
+		{"failure ratio at the bound", runOf(20, 4), nil, []string{}},
+		{"failure ratio above the bound", runOf(20, 5), nil, []string{"5 of 20 post elements failed"}},
+		{"every element failing", runOf(20, 20), nil, []string{"20 of 20 post elements failed"}},
+		{"drop to half", runOf(10, 0), runsOf(20, 20, 20), []string{}},
+		{"drop below half", runOf(9, 0), runsOf(20, 20, 20), []string{"9 post elements against a trailing average of 20.0"}},
+		{"drop with too few runs", runOf(1, 0), runsOf(20, 20), []string{}},
+		{"runs where the source was down ignored", runOf(1, 0), runsOf(20, 0, 20, 0), []string{}},
+		{"drop against the runs with elements", runOf(9, 0), runsOf(20, 0, 20, 20, 0), []string{"trailing average of 20.0"}},
+		{"failures and a drop", runOf(8, 2), runsOf(20, 20, 20), []string{"2 of 8 post elements failed", "trailing average of 20.0"}},
+	}
+	for _, c := range cases {
+		got := Check(c.run, c.previous)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if !strings.Contains(got[i], c.want[i]) {
+				t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	runs := []struct {
+		name    string
+		run     model.SourceRun
+		healthy bool
+	}{
+		{"first", runOf(20, 0), true},
+		{"markup changed", runOf(0, 0), false},
+		{"down", model.SourceRun{StatusCode: 500}, false},
+		{"recovered", runOf(20, 0), true},
+	}
+	for i, r := range runs {
+		r.run.Source, r.run.At = model.DataSourceFlyday, at.AddDate(0, 0, i)
+		health, err := Record(ctx, r.run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if health.Healthy != r.healthy || (r.healthy && !health.LastHealthy.Equal(r.run.At)) || (!r.healthy && !health.LastHealthy.Equal(at)) {
+			t.Errorf("%s: got %+v, want healthy %v", r.name, health, r.healthy)
+		}
+	}
+
+	sources, err := Status(ctx)
+	if err != nil || len(sources) != 1 || !sources[0].Healthy {
+		t.Errorf("got %+v, %v, want flyday healthy", sources, err)
+	}
+	recorded, err := repository.Health.Runs(ctx, model.DataSourceFlyday, trailingRuns)
+	if err != nil || len(recorded) != len(runs) {
+		t.Errorf("got %d runs, %v, want %d", len(recorded), err, len(runs))
+	}
+}
@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/archive"
+	"github.com/jeffyfung/flight-info-agg/pkg/lock"
+	"github.com/jeffyfung/flight-info-agg/pkg/matcher"
+	"github.com/jeffyfung/flight-info-agg/pkg/notification/telegram"
+	"github.com/jeffyfung/flight-info-agg/pkg/report"
+	"github.com/jeffyfung/flight-info-agg/pkg/retag"
+	"github.com/jeffyfung/flight-info-agg/pkg/scheduler"
+	"github.com/jeffyfung/flight-info-agg/pkg/scrapper"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	notifyConcurrency = 20
+
+	// how long Notify waits for another run to finish notifying
+	notifyWait = 10 * time.Minute
+
+	// diffs kept in the result of the retag job
+	retagDiffs = 20
+)
+
+// the job re-tagging the posts tagged with an older version of the dictionaries, also triggered from the admin
+// endpoints once they are edited
+const RetagJob = "retag"
+
+// sends the alerts and digests, replaced in tests
+var newNotifier = telegram.NewNotifier
+
+// schedules of the jobs, unless config.Cfg.Scheduler.Schedules has them. "scrape" is the schedule of
+// every "scrape:<source>" job without its own
+var defaultSchedules = map[string]string{
+	"scrape":  "0 * * * *",
+	"cleanup": "30 3 * * *",
+	"digest":  "0 9 * * 1",
+	RetagJob:  "0 4 * * *",
+}
+
+// Register adds a job scrapping and notifying of each source, the cleanup of old posts, the weekly tagging
+// digest and the re-tagging of posts. Specs must be loaded, so that the sources are known
+func Register(s *scheduler.Scheduler) error {
+	for _, source := range scrapper.Sources() {
+		source, name := source, "scrape:"+string(source)
+		err := s.Add(scheduler.Job{Name: name, Schedule: scheduleOf(name, "scrape"), Run: func(ctx context.Context) error {
+			return ScrapeAndNotify(ctx, source)
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.Add(scheduler.Job{Name: "cleanup", Schedule: scheduleOf("cleanup"), Run: func(ctx context.Context) error {
+		_, err := Cleanup(ctx, 0, false)
+		return err
+	}}); err != nil {
+		return err
+	}
+	if err := s.Add(scheduler.Job{Name: "digest", Schedule: scheduleOf("digest"), Run: Digest}); err != nil {
+		return err
+	}
+	return s.Add(scheduler.Job{Name: RetagJob, Schedule: scheduleOf(RetagJob), Run: Retag})
+}
+
+// scheduleOf returns the first schedule configured, then the first default, among the names
+func scheduleOf(names ...string) string {
+	for _, name := range names {
+		if schedule, ok := config.Cfg.Scheduler.Schedules[name]; ok {
+			return schedule
+		}
+	}
+	for _, name := range names {
+		if schedule, ok := defaultSchedules[name]; ok {
+			return schedule
+		}
+	}
+	return ""
+}
+
+// ScrapeAndNotify scrapes the sources named, all by default, and notifies users of the new posts
+func ScrapeAndNotify(ctx context.Context, sources ...model.DataSource) error {
+	report, err := scrapper.Run(ctx, scrapper.Options{Sources: sources})
+	if err != nil {
+		return err
+	}
+	notified, err := Notify(ctx, report.Posts, NotifyOptions{})
+	if err != nil {
+		return err
+	}
+	if len(report.Failed) > 0 || notified.Failed > 0 {
+		return errors.New(fmt.Sprintf("Cannot scrape %v, cannot notify %d users", report.Failed, notified.Failed))
+	}
+	return nil
+}
+
+// Cleanup moves the posts past the retention of their source to the archive, unless another run is already
+// archiving them. Months is the retention of the sources without their own, the configured one by default
+func Cleanup(ctx context.Context, months int, dryRun bool) (archive.Report, error) {
+	opts := archive.Options{Months: months, DryRun: dryRun}
+	if dryRun {
+		return archive.Run(ctx, opts)
+	}
+
+	lease, err := lock.Acquire(ctx, "cleanup")
+	if errors.Is(err, lock.ErrHeld) {
+		log.Println("Skipping cleanup as another run is cleaning up:", err.Error())
+		return archive.Report{}, nil
+	}
+	if err != nil {
+		return archive.Report{}, err
+	}
+	defer lease.Release()
+
+	// stops moving posts once another run may be moving them
+	return archive.Run(lease.Context(), opts)
+}
+
+type (
+	NotifyOptions struct {
+		Users  []string // only these users, whether or not they turned notifications on
+		DryRun bool     // print the alerts rather than send them
+	}
+
+	NotifyReport struct {
+		Users  int // matching at least one post
+		Failed int // not notified
+	}
+)
+
+// Notify sends the posts matching their subscriptions to users. Failed notifications are logged. Runs
+// notify one at a time, as the posts of each are its own and must not be dropped
+func Notify(ctx context.Context, posts []model.Post, opts NotifyOptions) (NotifyReport, error) {
+	report := NotifyReport{}
+	if len(posts) == 0 {
+		return report, nil
+	}
+	if !opts.DryRun {
+		lease, err := lock.Wait(ctx, "notify", notifyWait)
+		if err != nil {
+			return report, err
+		}
+		defer lease.Release()
+		// stops sending once another run may be notifying
+		ctx = lease.Context()
+	}
+
+	notifier := newNotifier()
+
+	index, err := matcher.LoadIndex(ctx, opts.Users...)
+	if err != nil {
+		return report, errors.New("Cannot get users" + err.(*errors.Error).ErrorStack())
+	}
+	matches := index.Match(posts)
+	report.Users = len(matches)
+
+	if opts.DryRun {
+		for _, m := range matches {
+			fmt.Printf("Would send user %s:\n%s\n\n", m.User.ID, notifier.FormatAlertMessages(m.User, m.Posts()))
+		}
+		return report, nil
+	}
+
+	var failed atomic.Int64
+	g := new(errgroup.Group)
+	g.SetLimit(notifyConcurrency)
+
+	for _, match := range matches {
+		g.Go(func(m matcher.Match) func() error {
+			return func() error {
+				content := notifier.FormatAlertMessages(m.User, m.Posts())
+				err := notifier.Notify(ctx, m.User, content)
+				if err != nil {
+					failed.Add(1)
+				}
+				return err
+			}
+		}(match))
+	}
+
+	if err := g.Wait(); err != nil {
+		log.Println("Cannot send email: " + err.(*errors.Error).ErrorStack())
+	}
+	report.Failed = int(failed.Load())
+
+	return report, nil
+
+}
+
+// Retag re-tags the posts tagged with an older version of the dictionaries, and keeps the summary of the run
+// with its first diffs as the result of the job
+func Retag(ctx context.Context) error {
+	r, err := retag.Run(ctx, retag.Options{MaxDiffs: retagDiffs})
+	if err != nil {
+		return err
+	}
+	scheduler.SetResult(ctx, r.String())
+	return nil
+}
+
+// Digest sends the admin chats a summary of the tagging report of the last week
+func Digest(ctx context.Context) error {
+	r, err := report.Build(ctx, report.Options{Days: 7, Limit: 10, MinCount: 3})
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Tagging of the %d posts since %s: %d without locations, %d without airlines, %d tagged by aliases only",
+		r.Scanned, r.Since.Format(time.DateOnly), r.NoLocations, r.NoAirlines, r.AliasesOnly)
+	if len(r.Suggestions) > 0 {
+		suggestions := []string{}
+		for _, s := range r.Suggestions {
+			suggestions = append(suggestions, fmt.Sprintf("%s (%d)", s.Text, s.Count))
+		}
+		text += "\nSuggested aliases: " + strings.Join(suggestions, ", ")
+	}
+
+	notifier := newNotifier()
+	for _, chatID := range config.Cfg.Telegram.AdminChatIDs {
+		if err := notifier.NotifyChat(ctx, chatID, text); err != nil {
+			log.Println("Cannot send digest to admin chat", chatID, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/notification"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// fakeNotifier keeps the alerts sent by user, and fails to send to the user named fail
+type fakeNotifier struct {
+	mu   sync.Mutex
+	sent map[string]string
+	fail string
+}
+
+func (n *fakeNotifier) SetUp(context.Context) error {
+	return nil
+}
+
+func (n *fakeNotifier) Notify(_ context.Context, user model.User, text string) error {
+	if user.ID == n.fail {
+		return errors.New("Cannot send Telegram message: blocked")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent[user.ID] = text
+	return nil
+}
+
+func (n *fakeNotifier) NotifyChat(context.Context, int64, string) error {
+	return nil
+}
+
+func (n *fakeNotifier) FormatAlertMessages(_ model.User, posts []model.Post) string {
+	titles := []string{}
+	for _, post := range posts {
+		titles = append(titles, post.Title)
+	}
+	return strings.Join(titles, "\n")
+}
+
+var defaultNotifier = newNotifier
+
+// notified replaces the notifier of the jobs until the test is done
+func notified(t *testing.T, fail string) *fakeNotifier {
+	n := &fakeNotifier{sent: map[string]string{}, fail: fail}
+	newNotifier = func() notification.Notifier { return n }
+	t.Cleanup(func() {
+		newNotifier = defaultNotifier
+	})
+	return n
+}
+
+func insertUsers(t *testing.T, users ...model.User) {
+	for _, user := range users {
+		if err := repository.Users.Insert(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	repository.UseMemory()
+	insertUsers(t,
+		model.User{ID: "japan", Notification: model.NotificationOn, TelegramChatID: 1, Query: model.Query{SelectedLocations: []string{"JP"}}},
+		model.User{ID: "taiwan", Notification: model.NotificationOn, TelegramChatID: 2, Query: model.Query{SelectedLocations: []string{"TW"}}},
+		model.User{ID: "blocked", Notification: model.NotificationOn, TelegramChatID: 3},
+		model.User{ID: "off", Notification: model.NotificationOff, TelegramChatID: 4},
+	)
+	posts := []model.Post{
+		{Title: "東京來回", URL: "https://example.com/tokyo", Locations: []string{"TYO"}},
+		{Title: "台北來回", URL: "https://example.com/taipei", Locations: []string{"TPE"}},
+	}
+
+	cases := []struct {
+		name   string
+		posts  []model.Post
+		opts   NotifyOptions
+		want   NotifyReport
+		sent   map[string]string
+		failed string
+	}{
+		{
+			name: "subscribers", posts: posts, want: NotifyReport{Users: 3, Failed: 1}, failed: "blocked",
+			sent: map[string]string{"japan": "東京來回", "taiwan": "台北來回"},
+		},
+		{
+			name: "users named", posts: posts, opts: NotifyOptions{Users: []string{"off", "japan"}}, want: NotifyReport{Users: 2},
+			sent: map[string]string{"japan": "東京來回", "off": "東京來回\n台北來回"},
+		},
+		{name: "dry run", posts: posts, opts: NotifyOptions{DryRun: true}, want: NotifyReport{Users: 3}, sent: map[string]string{}},
+		{name: "no posts", posts: []model.Post{}, want: NotifyReport{}, sent: map[string]string{}},
+	}
+	for _, c := range cases {
+		n := notified(t, c.failed)
+		got, err := Notify(context.Background(), c.posts, c.opts)
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %+v, %v, want %+v", c.name, got, err, c.want)
+		}
+		if len(n.sent) != len(c.sent) {
+			t.Errorf("%s: got %v sent, want %v", c.name, n.sent, c.sent)
+		}
+		for user, text := range c.sent {
+			if n.sent[user] != text {
+				t.Errorf("%s: got %q sent to %s, want %q", c.name, n.sent[user], user, text)
+			}
+		}
+	}
+}
+
+func TestScrapeAndNotify(t *testing.T) {
+	repository.UseMemory()
+	feed, err := os.ReadFile("../scrapper/testdata/deals-rss/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(feed)
+	}))
+	defer server.Close()
+
+	scrapper := config.Cfg.Scrapper
+	config.Cfg.Scrapper.Feeds = config.Feeds{"deals": server.URL + "/feed.xml"}
+	config.Cfg.Scrapper.CacheDir = t.TempDir()
+	defer func() { config.Cfg.Scrapper = scrapper }()
+
+	insertUsers(t,
+		model.User{ID: "osaka", Notification: model.NotificationOn, TelegramChatID: 1, Query: model.Query{SelectedLocations: []string{"OSA"}}},
+		model.User{ID: "taiwan", Notification: model.NotificationOn, TelegramChatID: 2, Query: model.Query{SelectedLocations: []string{"TW"}}},
+		model.User{ID: "london", Notification: model.NotificationOn, TelegramChatID: 3, Query: model.Query{SelectedLocations: []string{"LON"}}},
+	)
+
+	n := notified(t, "")
+	if err := ScrapeAndNotify(context.Background(), "deals"); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.sent) != 2 || n.sent["osaka"] != "Peach 樂桃 大阪 沖繩 單程$398" || n.sent["taiwan"] != "長榮航空 台北來回$1,280起" {
+		t.Errorf("got %v sent, want the posts of the feed to osaka and taiwan", n.sent)
+	}
+
+	// the posts are stored by then
+	n = notified(t, "")
+	if err := ScrapeAndNotify(context.Background(), "deals"); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.sent) != 0 {
+		t.Errorf("got %v sent again, want none", n.sent)
+	}
+
+	status = http.StatusNotFound
+	if err := ScrapeAndNotify(context.Background(), "deals"); err == nil || !strings.Contains(err.Error(), "deals") {
+		t.Errorf("got %v, want the source failed", err)
+	}
+	if err := ScrapeAndNotify(context.Background(), "unknown"); err == nil {
+		t.Error("got no error for an unknown source")
+	}
+}
@@ -0,0 +1,63 @@
+package languages
+
+import "strings"
+
+// TC and SC characters, in pairs. Only characters that convert one to one are listed: e.g. 里 is left out as
+// it stands for both 里 and 裡, and would wrongly convert 里斯本
+const tcSCPairs = "" +
+	"亞亚來来個个倫伦價价優优兒儿內内區区單单國国園园圖图團团奧奥婁娄峴岘島岛廣广徹彻" +
+	"愛爱撾挝時时東东榮荣樂乐機机檳槟歐欧沖冲減减漢汉澤泽濟济灘滩灣湾烏乌爾尔獅狮磯矶" +
+	"稅税約约納纳紐纽經经維维線线緬缅縣县繩绳羅罗聯联臘腊艙舱茲兹莊庄華华萊莱薩萨蘇苏" +
+	"蘭兰貝贝買买賓宾轉转這这連连遊游運运達达邁迈開开門门關关陸陆際际雙双雲云靈灵韓韩" +
+	"須须頓顿頭头飛飞馬马魯鲁鷹鹰點点龍龙長长邊边進进務务動动勞劳廳厅體体倉仓傳传億亿" +
+	"則则劃划劍剑勝胜協协參参發发變变嶼屿崗岗嶺岭岡冈峽峡條条棧栈樓楼標标歸归氣气湧涌" +
+	"溝沟濱滨無无熱热爭争牆墙獎奖環环產产畫画當当盧卢碼码禮礼種种稱称積积穩稳築筑簡简" +
+	"類类紅红紀纪級级細细組组結结給给統统絲丝綠绿網网總总續续聖圣聞闻聽听興兴舉举舊旧" +
+	"號号衛卫裝装補补見见規规視视覽览觀观計计訂订記记許许設设評评詢询試试話话認认語语" +
+	"說说請请論论講讲證证識识譯译議议護护讓让費费貨货購购貴贵資资賽赛趙赵車车軍军輕轻" +
+	"載载輪轮輸输辦办農农過过還还選选遠远適适鄉乡醫医銀银錢钱錦锦鐘钟鐵铁閘闸間间閣阁" +
+	"陽阳隊队階阶險险隨随雞鸡離离難难電电預预頁页順顺領领頻频題题額额風风飯饭飲饮館馆" +
+	"驗验別别霧雾溫温諾诺廈厦葉叶瑪玛瓊琼貿贸漁渔盤盘鎮镇鄭郑陳陈張张劉刘吳吴黃黄楊杨" +
+	"麥麦節节會会員员搶抢閃闪壓压慶庆誕诞場场勁劲筍笋鳥鸟魚鱼鮮鲜雖虽廟庙寶宝實实寫写" +
+	"將将專专對对尋寻導导層层屬属帶带幣币幫帮廠厂廢废彎弯戲戏戰战據据擇择擊击擔担擁拥" +
+	"擴扩攝摄數数斷断晉晋書书朧胧極极構构樣样橋桥檢检權权歡欢殘残殺杀湯汤滿满漲涨潛潜" +
+	"濕湿燈灯爐炉犧牺狀状獨独現现瑤瑶畢毕異异療疗皚皑盡尽監监確确碩硕禪禅窮穷競竞筆笔" +
+	"糧粮紙纸紛纷絕绝綿绵緣缘縮缩罰罚習习聰聪膠胶臉脸臨临艷艳藝艺蘆芦處处虛虚蛻蜕" +
+	"術术襲袭訪访詳详誠诚調调談谈謝谢豐丰貓猫質质趕赶跡迹蹤踪軟软較较輛辆辭辞" +
+	"遞递遲迟鄰邻釣钓鋪铺錄录鍋锅鏡镜鐳镭闊阔隱隐雜杂靜静韻韵響响頂顶項项顧顾顯显飄飘" +
+	"飽饱驚惊鬧闹鹽盐麗丽齊齐齡龄"
+
+var (
+	tcToSC = map[rune]rune{}
+	scToTC = map[rune]rune{}
+)
+
+func init() {
+	pairs := []rune(tcSCPairs)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tcToSC[pairs[i]] = pairs[i+1]
+		scToTC[pairs[i+1]] = pairs[i]
+	}
+}
+
+// ToTC converts SC characters to TC ones, leaving other characters as they are
+func ToTC(s string) string {
+	return strings.Map(toTCRune, s)
+}
+
+// ToSC converts TC characters to SC ones, leaving other characters as they are
+func ToSC(s string) string {
+	return strings.Map(func(r rune) rune {
+		if sc, ok := tcToSC[r]; ok {
+			return sc
+		}
+		return r
+	}, s)
+}
+
+func toTCRune(r rune) rune {
+	if tc, ok := scToTC[r]; ok {
+		return tc
+	}
+	return r
+}
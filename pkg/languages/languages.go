@@ -1,5 +1,10 @@
 package languages
 
+import (
+	"strings"
+	"unicode"
+)
+
 type (
 	Lang int
 )
@@ -7,4 +12,31 @@ type (
 const (
 	EN Lang = iota
 	TC
+	SC
 )
+
+// Parse reads a locale such as "en-US", "zh-HK" or "zh-Hans", or an Accept-Language header, picking the
+// first language supported
+func Parse(locale string) (Lang, bool) {
+	for _, part := range strings.Split(locale, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+		switch {
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			return EN, true
+		case tag == "sc" || tag == "zh-cn" || tag == "zh-sg" || strings.HasPrefix(tag, "zh-hans"):
+			return SC, true
+		case tag == "tc" || tag == "zh" || strings.HasPrefix(tag, "zh-"):
+			return TC, true
+		}
+	}
+	return TC, false
+}
+
+// Fold lower-cases s and converts it to TC, so that EN, TC and SC texts compare equal. Runes are mapped one
+// for one, so that offsets in the folded text are offsets in s
+func Fold(s string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(toTCRune(r))
+	}, s)
+}
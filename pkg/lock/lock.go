@@ -0,0 +1,153 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// how often Wait tries to take a lease held by another
+const waitInterval = 5 * time.Second
+
+var (
+	// a lease not extended for this long is free to take, e.g. when its holder crashed
+	ttl = time.Minute
+	// how often a lease is extended while held
+	heartbeat = ttl / 3
+)
+
+var (
+	ErrHeld = errors.New("lock is held")
+	// the cause of the context of a lease lost, see Lease.Context
+	ErrLost = errors.New("lock is lost")
+)
+
+var (
+	// identifies this process among the holders of leases
+	process = processID()
+	leases  atomic.Int64
+)
+
+type Lease struct {
+	name   string
+	holder string // distinct for each lease, even of one process
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func processID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%x", host, os.Getpid(), rand.Uint32())
+}
+
+// Acquire takes the lease of the name, unless another holder has it and it has not expired. The lease is
+// extended in the background until released, whether or not ctx is done by then. Work done under the lease
+// should use its Context, so that it stops once the lease is lost
+func Acquire(ctx context.Context, name string) (*Lease, error) {
+	holder := fmt.Sprintf("%s#%d", process, leases.Add(1))
+	now := time.Now().UTC()
+	current, err := repository.Locks.Acquire(ctx, model.Lease{Name: name, Holder: holder, AcquiredAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return nil, err
+	}
+	if current.Holder == "" {
+		return nil, errors.WrapPrefix(ErrHeld, name, 0)
+	}
+	if current.Holder != holder {
+		return nil, errors.WrapPrefix(ErrHeld, fmt.Sprintf("%s by %s until %s", name, current.Holder, current.ExpiresAt.Format(time.RFC3339)), 0)
+	}
+
+	lease := &Lease{name: name, holder: holder, stop: make(chan struct{})}
+	lease.ctx, lease.cancel = context.WithCancelCause(ctx)
+	lease.wg.Add(1)
+	go lease.extend()
+	return lease, nil
+}
+
+// Wait acquires the lease of the name once its holder releases it or it expires, for up to timeout or until
+// ctx is done
+func Wait(ctx context.Context, name string, timeout time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lease, err := Acquire(ctx, name)
+		if !errors.Is(err, ErrHeld) || time.Now().Add(waitInterval).After(deadline) {
+			return lease, err
+		}
+		log.Println("Waiting for lock:", err.Error())
+		select {
+		case <-time.After(waitInterval):
+		case <-ctx.Done():
+			return nil, errors.New("Cannot acquire lock " + name + ": " + ctx.Err().Error())
+		}
+	}
+}
+
+// Held returns the leases of the names that are held, i.e. not expired, by name
+func Held(ctx context.Context, names ...string) (map[string]model.Lease, error) {
+	return repository.Locks.Held(ctx, names, time.Now().UTC())
+}
+
+// Context is done once the lease is lost or released, or once the context it was acquired with is done.
+// Its cause is ErrLost when the lease is lost, i.e. found taken or not extended before it could expire, as
+// another holder may take it over from then
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+func (l *Lease) extend() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	extended := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.stop:
+			return
+		}
+		held, err := repository.Locks.Extend(context.Background(), l.name, l.holder, time.Now().UTC().Add(ttl))
+		if err != nil {
+			log.Println(err.Error())
+			// given up before the lease can expire, rather than after another holder may have taken it
+			if time.Since(extended)+heartbeat < ttl {
+				continue
+			}
+			log.Println("Lost lock", l.name, "as it cannot be extended")
+			l.cancel(errors.WrapPrefix(ErrLost, l.name, 0))
+			return
+		}
+		if !held {
+			log.Println("Lost lock", l.name, "as it expired before being extended")
+			l.cancel(errors.WrapPrefix(ErrLost, l.name, 0))
+			return
+		}
+		extended = time.Now()
+	}
+}
+
+// Release stops extending the lease and frees it for other holders
+func (l *Lease) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		l.wg.Wait()
+		l.cancel(nil)
+		if err := repository.Locks.Release(context.Background(), l.name, l.holder); err != nil {
+			log.Println(err.Error())
+		}
+	})
+}
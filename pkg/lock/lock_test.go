@@ -0,0 +1,133 @@
+package lock
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// failingLocks cannot extend leases once failing, as when the database is down
+type failingLocks struct {
+	repository.LockRepository
+	failing *atomic.Bool
+}
+
+func (l failingLocks) Extend(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	if l.failing.Load() {
+		return false, errors.New("Cannot extend lock: connection refused")
+	}
+	return l.LockRepository.Extend(ctx, name, holder, until)
+}
+
+// shortLeases makes leases expire and be extended quickly until the test is done
+func shortLeases(t *testing.T) {
+	defaultTTL, defaultHeartbeat := ttl, heartbeat
+	ttl, heartbeat = 300*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		ttl, heartbeat = defaultTTL, defaultHeartbeat
+	})
+}
+
+func TestAcquireHeld(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	lease, err := Acquire(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Acquire(ctx, "test"); !errors.Is(err, ErrHeld) {
+		t.Errorf("got %v while held, want ErrHeld", err)
+	}
+	held, err := Held(ctx, "test", "other")
+	if err != nil || len(held) != 1 || held["test"].Holder != lease.holder {
+		t.Errorf("got %+v, %v, want the lease held", held, err)
+	}
+
+	lease.Release()
+	if lease.Context().Err() == nil || errors.Is(context.Cause(lease.Context()), ErrLost) {
+		t.Errorf("got %v once released, want cancelled without ErrLost", context.Cause(lease.Context()))
+	}
+	again, err := Acquire(ctx, "test")
+	if err != nil {
+		t.Fatalf("got %v once released, want the lease", err)
+	}
+	again.Release()
+}
+
+func TestAcquireExpired(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	// left by a holder that crashed
+	at := time.Now().UTC().Add(-2 * ttl)
+	_, err := repository.Locks.Acquire(ctx, model.Lease{Name: "test", Holder: "crashed", AcquiredAt: at, ExpiresAt: at.Add(ttl)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := Acquire(ctx, "test")
+	if err != nil {
+		t.Fatalf("got %v, want the expired lease taken over", err)
+	}
+	defer lease.Release()
+	held, err := Held(ctx, "test")
+	if err != nil || held["test"].Holder != lease.holder {
+		t.Errorf("got %+v, %v, want the lease held by the new holder", held, err)
+	}
+}
+
+func TestLost(t *testing.T) {
+	var failing atomic.Bool
+	cases := []struct {
+		name string
+		lose func(t *testing.T, lease *Lease)
+	}{
+		{"taken over", func(t *testing.T, lease *Lease) {
+			// as if it expired before the heartbeat, and another holder took it
+			ctx := context.Background()
+			now := time.Now().UTC()
+			if err := repository.Locks.Release(ctx, lease.name, lease.holder); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repository.Locks.Acquire(ctx, model.Lease{Name: lease.name, Holder: "other", AcquiredAt: now, ExpiresAt: now.Add(ttl)}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"cannot extend", func(t *testing.T, lease *Lease) {
+			failing.Store(true)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repository.UseMemory()
+			failing.Store(false)
+			repository.Locks = failingLocks{repository.Locks, &failing}
+			shortLeases(t)
+			lease, err := Acquire(context.Background(), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lease.Release()
+
+			// extended while held
+			time.Sleep(2 * heartbeat)
+			if lease.Context().Err() != nil {
+				t.Fatalf("got %v before losing the lease", context.Cause(lease.Context()))
+			}
+
+			c.lose(t, lease)
+			select {
+			case <-lease.Context().Done():
+			case <-time.After(2 * ttl):
+				t.Fatal("lease context not cancelled once lost")
+			}
+			if cause := context.Cause(lease.Context()); !errors.Is(cause, ErrLost) {
+				t.Errorf("got %v, want ErrLost", cause)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package matcher
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+type BenchResult struct {
+	Users     int
+	Posts     int
+	Matches   int
+	BuildTime time.Duration
+	MatchTime time.Duration
+	HeapAlloc uint64 // bytes allocated while building the index and matching
+	Retained  uint64 // bytes still live afterwards, i.e. the index and the matches
+}
+
+func (r BenchResult) String() string {
+	return fmt.Sprintf("users=%d posts=%d matched_users=%d build=%v match=%v total=%v alloc=%.1fMB retained=%.1fMB",
+		r.Users, r.Posts, r.Matches, r.BuildTime, r.MatchTime, r.BuildTime+r.MatchTime,
+		float64(r.HeapAlloc)/1e6, float64(r.Retained)/1e6)
+}
+
+// Benchmark builds an index over synthetic users and matches synthetic posts against it. Roughly a
+// third of the users leave each filter empty, as the "match everything" wildcard is the common case
+func Benchmark(users int, posts int, seed int64) BenchResult {
+	rnd := rand.New(rand.NewSource(seed))
+	locations := tags.PlaceCodes()
+	airlines := tags.AirlineCodes()
+
+	syntheticPosts := make([]model.Post, posts)
+	for i := range syntheticPosts {
+		syntheticPosts[i] = model.Post{
+			Title:     fmt.Sprintf("post %d", i),
+			Locations: pick(rnd, locations, 1+rnd.Intn(3)),
+			Airlines:  pick(rnd, airlines, rnd.Intn(3)),
+		}
+	}
+
+	syntheticUsers := make([]model.User, users)
+	for i := range syntheticUsers {
+		syntheticUsers[i] = model.User{ID: fmt.Sprintf("user-%d", i), Notification: model.NotificationOn}
+		if rnd.Intn(3) > 0 {
+			syntheticUsers[i].SelectedLocations = pick(rnd, locations, 1+rnd.Intn(5))
+		}
+		if rnd.Intn(3) > 0 {
+			syntheticUsers[i].SelectedAirlines = pick(rnd, airlines, 1+rnd.Intn(3))
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	idx := NewIndex()
+	for _, user := range syntheticUsers {
+		idx.Add(user)
+	}
+	buildTime := time.Since(start)
+
+	start = time.Now()
+	matches := idx.Match(syntheticPosts)
+	matchTime := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	runtime.GC()
+	var retained runtime.MemStats
+	runtime.ReadMemStats(&retained)
+	runtime.KeepAlive(idx)
+	runtime.KeepAlive(matches)
+
+	return BenchResult{
+		Users:     users,
+		Posts:     posts,
+		Matches:   len(matches),
+		BuildTime: buildTime,
+		MatchTime: matchTime,
+		HeapAlloc: after.TotalAlloc - before.TotalAlloc,
+		Retained:  retained.HeapAlloc - min(retained.HeapAlloc, before.HeapAlloc),
+	}
+}
+
+func pick(rnd *rand.Rand, values []string, n int) []string {
+	picked := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		picked = append(picked, values[rnd.Intn(len(values))])
+	}
+	return picked
+}
@@ -0,0 +1,163 @@
+package matcher
+
+import (
+	"context"
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+type (
+	// Index maps tags to the subscriptions that select them. Subscriptions with an empty filter are
+	// kept in the wildcard lists since they match any post
+	Index struct {
+		subs        []model.User
+		byLocation  map[string][]int
+		byAirline   map[string][]int
+		anyLocation []int
+		anyAirline  []int
+	}
+
+	// Match refers to the matched posts by position so that large fan-outs do not copy every post per user
+	Match struct {
+		User    model.User
+		posts   []model.Post
+		indexes []int32
+	}
+)
+
+func NewIndex() *Index {
+	return &Index{
+		byLocation: map[string][]int{},
+		byAirline:  map[string][]int{},
+	}
+}
+
+// LoadIndex streams the users who have notifications turned on into a new index. With user IDs, only
+// these users are indexed, whether or not they turned notifications on, e.g. to test their alerts
+func LoadIndex(ctx context.Context, userIDs ...string) (*Index, error) {
+	idx := NewIndex()
+	err := repository.Users.EachSubscriber(ctx, userIDs, func(user model.User) error {
+		idx.Add(user)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("Cannot stream users: " + err.Error())
+	}
+	return idx, nil
+}
+
+// Add keeps only the fields needed to match and notify the user
+func (idx *Index) Add(user model.User) {
+	i := len(idx.subs)
+	idx.subs = append(idx.subs, model.User{
+		Query:          user.Query,
+		ID:             user.ID,
+		Notification:   user.Notification,
+		TelegramChatID: user.TelegramChatID,
+	})
+
+	if len(user.SelectedLocations) == 0 {
+		idx.anyLocation = append(idx.anyLocation, i)
+	}
+	// a subscription to a place also matches the places under it
+	for _, loc := range tags.WithDescendants(user.SelectedLocations) {
+		idx.byLocation[loc] = append(idx.byLocation[loc], i)
+	}
+
+	if len(user.SelectedAirlines) == 0 {
+		idx.anyAirline = append(idx.anyAirline, i)
+	}
+	// a subscription to an airline group matches the airlines in it
+	for _, airline := range tags.ExpandAirlines(user.SelectedAirlines) {
+		idx.byAirline[airline] = append(idx.byAirline[airline], i)
+	}
+}
+
+func (idx *Index) Len() int {
+	return len(idx.subs)
+}
+
+// Match returns, for every subscription matching at least one post, the posts it matches. A subscription
+// matches a post when both its location and airline filters are either empty or overlap with the post's tags
+func (idx *Index) Match(posts []model.Post) []Match {
+	// stamps are set to the post number + 1 so that they never need to be reset between posts
+	locStamp := make([]int32, len(idx.subs))
+	airStamp := make([]int32, len(idx.subs))
+	matched := make([][]int32, len(idx.subs))
+
+	for p, post := range posts {
+		stamp := int32(p + 1)
+
+		locCount := mark(locStamp, stamp, idx.anyLocation)
+		for _, loc := range post.Locations {
+			locCount += mark(locStamp, stamp, idx.byLocation[loc])
+		}
+		if locCount == 0 {
+			continue
+		}
+
+		airCount := mark(airStamp, stamp, idx.anyAirline)
+		for _, airline := range post.Airlines {
+			airCount += mark(airStamp, stamp, idx.byAirline[airline])
+		}
+		if airCount == 0 {
+			continue
+		}
+
+		// walk the smaller side and check membership of the other through its stamps
+		walk, walkTags, otherStamp := idx.anyLocation, post.Locations, airStamp
+		byTag := idx.byLocation
+		if airCount < locCount {
+			walk, walkTags, otherStamp = idx.anyAirline, post.Airlines, locStamp
+			byTag = idx.byAirline
+		}
+		visit := func(i int) {
+			if otherStamp[i] != stamp {
+				return
+			}
+			if n := len(matched[i]); n > 0 && matched[i][n-1] == stamp {
+				return
+			}
+			matched[i] = append(matched[i], stamp)
+		}
+		for _, i := range walk {
+			visit(i)
+		}
+		for _, tag := range walkTags {
+			for _, i := range byTag[tag] {
+				visit(i)
+			}
+		}
+	}
+
+	output := []Match{}
+	for i, stamps := range matched {
+		if len(stamps) == 0 {
+			continue
+		}
+		output = append(output, Match{User: idx.subs[i], posts: posts, indexes: stamps})
+	}
+	return output
+}
+
+func (m Match) Posts() []model.Post {
+	matchedPosts := make([]model.Post, len(m.indexes))
+	for i, stamp := range m.indexes {
+		matchedPosts[i] = m.posts[stamp-1]
+	}
+	return matchedPosts
+}
+
+// returns the number of subscriptions newly marked
+func mark(stamps []int32, stamp int32, subs []int) int {
+	count := 0
+	for _, i := range subs {
+		if stamps[i] != stamp {
+			stamps[i] = stamp
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,138 @@
+package matcher
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+// naive matches every subscription against every post, as alerts did before the index
+func naive(users []model.User, posts []model.Post) map[string][]string {
+	output := map[string][]string{}
+	for _, user := range users {
+		locations := tags.WithDescendants(user.SelectedLocations)
+		airlines := tags.ExpandAirlines(user.SelectedAirlines)
+		for _, post := range posts {
+			if len(locations) > 0 && !slices.ContainsFunc(post.Locations, func(l string) bool { return slices.Contains(locations, l) }) {
+				continue
+			}
+			if len(airlines) > 0 && !slices.ContainsFunc(post.Airlines, func(a string) bool { return slices.Contains(airlines, a) }) {
+				continue
+			}
+			output[user.ID] = append(output[user.ID], post.Title)
+		}
+	}
+	return output
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		users []model.User
+		posts []model.Post
+		want  map[string][]string
+	}{
+		{
+			name: "filters",
+			users: []model.User{
+				{ID: "any"},
+				{ID: "japan", Query: model.Query{SelectedLocations: []string{"JP"}}},
+				{ID: "tokyo-cathay", Query: model.Query{SelectedLocations: []string{"TYO"}, SelectedAirlines: []string{"CX"}}},
+				{ID: "oneworld", Query: model.Query{SelectedAirlines: []string{string(tags.AllianceOneworld)}}},
+				{ID: "nothing", Query: model.Query{SelectedLocations: []string{"LAX"}}},
+			},
+			posts: []model.Post{
+				{Title: "haneda-cathay", Locations: []string{"HND"}, Airlines: []string{"CX"}},
+				{Title: "taipei-eva", Locations: []string{"TPE"}, Airlines: []string{"BR"}},
+				{Title: "osaka", Locations: []string{"OSA"}},
+			},
+			want: map[string][]string{
+				"any":          {"haneda-cathay", "taipei-eva", "osaka"},
+				"japan":        {"haneda-cathay", "osaka"},
+				"tokyo-cathay": {"haneda-cathay"},
+				"oneworld":     {"haneda-cathay"},
+			},
+		},
+		{
+			name:  "no posts",
+			users: []model.User{{ID: "any"}},
+			posts: []model.Post{},
+			want:  map[string][]string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := match(c.users, c.posts); !equal(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+			if got := naive(c.users, c.posts); !equal(got, c.want) {
+				t.Errorf("naive: got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestMatchLikeNaive checks the index against naive matching over synthetic users and posts
+func TestMatchLikeNaive(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	locations := tags.PlaceCodes()
+	airlines := append(tags.AirlineCodes(), string(tags.AllianceStar), string(tags.AllianceSkyTeam))
+
+	users := make([]model.User, 2000)
+	for i := range users {
+		users[i] = model.User{ID: fmt.Sprintf("user-%d", i)}
+		if rnd.Intn(3) > 0 {
+			users[i].SelectedLocations = pick(rnd, locations, 1+rnd.Intn(3))
+		}
+		if rnd.Intn(3) > 0 {
+			users[i].SelectedAirlines = pick(rnd, airlines, 1+rnd.Intn(2))
+		}
+	}
+	posts := make([]model.Post, 100)
+	for i := range posts {
+		posts[i] = model.Post{Title: fmt.Sprintf("post %d", i), Locations: pick(rnd, locations, 1+rnd.Intn(3)),
+			Airlines: pick(rnd, airlines[:len(airlines)-2], rnd.Intn(3))}
+	}
+
+	got, want := match(users, posts), naive(users, posts)
+	if !equal(got, want) {
+		t.Errorf("index matched %d users, naive matching %d", len(got), len(want))
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Benchmark(100_000, 200, int64(i))
+	}
+}
+
+func match(users []model.User, posts []model.Post) map[string][]string {
+	idx := NewIndex()
+	for _, user := range users {
+		idx.Add(user)
+	}
+	output := map[string][]string{}
+	for _, m := range idx.Match(posts) {
+		for _, post := range m.Posts() {
+			output[m.User.ID] = append(output[m.User.ID], post.Title)
+		}
+	}
+	return output
+}
+
+func equal(a map[string][]string, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, titles := range a {
+		if !slices.Equal(titles, b[id]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dedupePostURLs keeps the latest created post of each URL, as posts stored before URLs were unique may
+// be duplicated, e.g. by scrapes running at the same time, and would fail the unique index
+func dedupePostURLs(ctx context.Context) error {
+	type duplicates struct {
+		URL string               `bson:"_id"`
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$url"}, {Key: "ids", Value: bson.M{"$push": "$_id"}}}}},
+		{{Key: "$match", Value: bson.D{{Key: "ids.1", Value: bson.M{"$exists": true}}}}},
+	}
+	found, err := mongoDB.Aggregate[duplicates](ctx, repository.PostsColl, pipeline)
+	if err != nil {
+		return errors.New("Cannot find duplicated posts: " + err.Error())
+	}
+
+	removed := int64(0)
+	for _, d := range found {
+		stale := d.IDs[1:]
+		result, err := mongoDB.DeleteMany(ctx, repository.PostsColl, bson.D{{Key: "_id", Value: bson.M{"$in": stale}}})
+		if err != nil {
+			return errors.New("Cannot remove duplicated posts: " + err.Error())
+		}
+		fmt.Printf("Kept post %s of %s, removed %v\n", d.IDs[0].Hex(), d.URL, stale)
+		removed += result.DeletedCount
+	}
+	fmt.Printf("Removed %d duplicated posts of %d URLs\n", removed, len(found))
+	return nil
+}
+
+// postsIndexes indexes the filters of the posts API and of alerts, latest first. Locations and airlines
+// are both arrays, which MongoDB cannot index together, hence an index each. URLs are unique, as scrapes
+// dedupe on them, once dedupePostURLs has removed the duplicates stored before
+func postsIndexes(ctx context.Context) error {
+	return createIndexes(ctx, repository.PostsColl, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "locations", Value: 1}, {Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "airlines", Value: 1}, {Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "pub_date", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "url", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// posts are mostly in Chinese, which has no stemming nor stop words in MongoDB
+		{
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "summary", Value: "text"}, {Key: "body", Value: "text"}},
+			Options: options.Index().
+				SetName("posts_text").
+				SetDefaultLanguage("none").
+				SetWeights(bson.D{{Key: "title", Value: 10}, {Key: "summary", Value: 5}, {Key: "body", Value: 1}}),
+		},
+	})
+}
+
+// usersIndexes indexes the lookup of the Telegram webhook and the subscribers streamed by alerts
+func usersIndexes(ctx context.Context) error {
+	return createIndexes(ctx, repository.UsersColl, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "telegram_uid", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "telegram_uid", Value: bson.M{"$gt": ""}}}),
+		},
+		{Keys: bson.D{{Key: "notification", Value: 1}}},
+	})
+}
+
+// archiveIndexes indexes the archived posts the posts API includes and scrapes dedupe on
+func archiveIndexes(ctx context.Context) error {
+	return createIndexes(ctx, repository.ArchiveColl, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "locations", Value: 1}, {Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "airlines", Value: 1}, {Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "pub_date", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "url", Value: 1}}},
+	})
+}
+
+// stateIndexes indexes the triggers polled by pkg/scheduler and the trailing runs read by pkg/health, and
+// lets MongoDB delete the leases of pkg/lock left expired, e.g. of sources removed since
+func stateIndexes(ctx context.Context) error {
+	if err := createIndexes(ctx, repository.JobsColl, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "triggered_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}); err != nil {
+		return err
+	}
+	if err := createIndexes(ctx, repository.LocksColl, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}); err != nil {
+		return err
+	}
+	return createIndexes(ctx, repository.RunsColl, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "at", Value: -1}}},
+	})
+}
+
+func createIndexes(ctx context.Context, coll string, indexes []mongo.IndexModel) error {
+	names, err := mongoDB.CreateIndexes(ctx, coll, indexes)
+	if err != nil {
+		return errors.New("Cannot create indexes on " + coll + ": " + err.Error())
+	}
+	fmt.Printf("Indexed %s: %v\n", coll, names)
+	return nil
+}
@@ -0,0 +1,116 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/lock"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// how long a run waits for another one to finish, e.g. of an instance starting at the same time
+const lockWait = 10 * time.Minute
+
+// Step changes the schema or the data once. Up must be safe to run again, as a run stopped before the step
+// is recorded runs it again
+type Step struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+	// the step only changes data stored in MongoDB as it was before pkg/repository, e.g. its indexes, and is
+	// recorded without being run on other storage, whose schema is migrated with it
+	MongoOnly bool
+}
+
+// Status is a step and its record, nil while it is pending
+type Status struct {
+	Version int
+	Name    string
+	Applied *model.AppliedMigration
+}
+
+// steps in the order they are applied. Released steps are never changed or reordered, later changes are
+// new steps
+var steps = []Step{
+	{Version: 1, Name: "tags-to-codes", Up: tagsToCodes, MongoOnly: true},
+	{Version: 2, Name: "seed-missing-tag-fields", Up: seedMissingTagFields},
+	{Version: 3, Name: "backfill-telegram-uids", Up: backfillTelegramUIDs, MongoOnly: true},
+	{Version: 4, Name: "dedupe-post-urls", Up: dedupePostURLs, MongoOnly: true},
+	{Version: 5, Name: "posts-indexes", Up: postsIndexes, MongoOnly: true},
+	{Version: 6, Name: "users-indexes", Up: usersIndexes, MongoOnly: true},
+	{Version: 7, Name: "archive-indexes", Up: archiveIndexes, MongoOnly: true},
+	{Version: 8, Name: "jobs-locks-and-source-runs-indexes", Up: stateIndexes, MongoOnly: true},
+	{Version: 9, Name: "seed-sc-names-and-ambiguous-codes", Up: seedMissingTagFields},
+}
+
+// List returns every step along with its record
+func List(ctx context.Context) ([]Status, error) {
+	records, err := repository.Migrations.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]model.AppliedMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	output := []Status{}
+	for _, step := range steps {
+		status := Status{Version: step.Version, Name: step.Name}
+		if record, ok := applied[step.Version]; ok {
+			status.Applied = &record
+		}
+		output = append(output, status)
+	}
+	return output, nil
+}
+
+// Run applies the pending steps in order and returns the steps applied. It stops at the first step that
+// fails, which is run again by the next run. Runs take turns, so that instances starting together apply
+// each step once
+func Run(ctx context.Context) ([]Step, error) {
+	applied := []Step{}
+	lease, err := lock.Wait(ctx, "migrate", lockWait)
+	if err != nil {
+		return applied, err
+	}
+	defer lease.Release()
+	// so that no step is applied twice once another run may take over
+	ctx = lease.Context()
+
+	// read once the lease is held, as another run may have applied steps
+	statuses, err := List(ctx)
+	if err != nil {
+		return applied, err
+	}
+	for i, status := range statuses {
+		if status.Applied != nil {
+			continue
+		}
+		step := steps[i]
+		start := time.Now().UTC()
+		if step.MongoOnly && repository.Storage() != repository.StorageMongo {
+			fmt.Printf("Skipping migration %d %s on %s storage\n", step.Version, step.Name, repository.Storage())
+		} else {
+			fmt.Printf("Applying migration %d %s\n", step.Version, step.Name)
+			if err = step.Up(ctx); err != nil {
+				return applied, errors.New(fmt.Sprintf("Cannot apply migration %d %s: %s", step.Version, step.Name, err.Error()))
+			}
+		}
+
+		record := model.AppliedMigration{
+			Version:   step.Version,
+			Name:      step.Name,
+			AppliedAt: start,
+			Duration:  time.Since(start).Milliseconds(),
+		}
+		if err = repository.Migrations.Record(ctx, record); err != nil {
+			return applied, err
+		}
+		applied = append(applied, step)
+	}
+	return applied, nil
+}
@@ -0,0 +1,126 @@
+package migration
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+func TestStepsOrdered(t *testing.T) {
+	names := map[string]bool{}
+	for i, step := range steps {
+		if step.Version != i+1 {
+			t.Errorf("step %s has version %d, want %d", step.Name, step.Version, i+1)
+		}
+		if step.Name == "" || names[step.Name] || step.Up == nil {
+			t.Errorf("step %d must have a distinct name and an Up", step.Version)
+		}
+		names[step.Name] = true
+	}
+}
+
+// fakeSteps replaces the steps until the test is done with steps recording their runs, failing while fail is
+// set to their version
+func fakeSteps(t *testing.T, ran *[]int, fail *int) {
+	defaultSteps := steps
+	up := func(version int) func(context.Context) error {
+		return func(context.Context) error {
+			if *fail == version {
+				return errors.New("Cannot migrate: interrupted")
+			}
+			*ran = append(*ran, version)
+			return nil
+		}
+	}
+	steps = []Step{
+		{Version: 1, Name: "first", Up: up(1)},
+		{Version: 2, Name: "second", Up: up(2)},
+		{Version: 3, Name: "mongo-indexes", Up: up(3), MongoOnly: true},
+		{Version: 4, Name: "fourth", Up: up(4)},
+	}
+	t.Cleanup(func() {
+		steps = defaultSteps
+	})
+}
+
+func versionsOf(applied []Step) []int {
+	versions := []int{}
+	for _, step := range applied {
+		versions = append(versions, step.Version)
+	}
+	return versions
+}
+
+func TestRun(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	ran, fail := []int{}, 2
+	fakeSteps(t, &ran, &fail)
+
+	// stops at the failing step, to be run again by the next run
+	applied, err := Run(ctx)
+	if err == nil || !slices.Equal(versionsOf(applied), []int{1}) || !slices.Equal(ran, []int{1}) {
+		t.Errorf("failing: got %v applied, %v ran, %v, want 1 and an error", versionsOf(applied), ran, err)
+	}
+
+	// MongoDB only steps are recorded without running on the memory storage
+	fail, ran = 0, []int{}
+	applied, err = Run(ctx)
+	if err != nil || !slices.Equal(versionsOf(applied), []int{2, 3, 4}) || !slices.Equal(ran, []int{2, 4}) {
+		t.Errorf("pending: got %v applied, %v ran, %v, want 2, 3 and 4 applied, 3 not run", versionsOf(applied), ran, err)
+	}
+
+	ran = []int{}
+	applied, err = Run(ctx)
+	if err != nil || len(applied) != 0 || len(ran) != 0 {
+		t.Errorf("none pending: got %v applied, %v ran, %v, want none", versionsOf(applied), ran, err)
+	}
+
+	statuses, err := List(ctx)
+	if err != nil || len(statuses) != 4 {
+		t.Fatalf("got %+v, %v, want 4 steps", statuses, err)
+	}
+	for i, status := range statuses {
+		if status.Version != i+1 || status.Applied == nil || status.Applied.Name != status.Name {
+			t.Errorf("got %+v, want step %d applied", status, i+1)
+		}
+	}
+}
+
+func TestRunSkipsRecorded(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	ran, fail := []int{}, 0
+	fakeSteps(t, &ran, &fail)
+	// as applied by an earlier run, or another instance
+	for _, version := range []int{1, 3} {
+		record := model.AppliedMigration{Version: version, Name: steps[version-1].Name, AppliedAt: time.Now().UTC()}
+		if err := repository.Migrations.Record(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	statuses, err := List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := []int{}
+	for _, status := range statuses {
+		if status.Applied == nil {
+			pending = append(pending, status.Version)
+		}
+	}
+	if !slices.Equal(pending, []int{2, 4}) {
+		t.Errorf("got %v pending, want 2 and 4", pending)
+	}
+
+	applied, err := Run(ctx)
+	if err != nil || !slices.Equal(versionsOf(applied), []int{2, 4}) || !slices.Equal(ran, []int{2, 4}) {
+		t.Errorf("got %v applied, %v ran, %v, want 2 and 4", versionsOf(applied), ran, err)
+	}
+}
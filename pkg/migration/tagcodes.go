@@ -0,0 +1,102 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"github.com/jeffyfung/flight-info-agg/pkg/dictionary"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TagsToCodes rewrites the TC display names stored as tags on posts and user selections to the codes of
+// the tag catalog. Values that are already codes are left untouched, so it is safe to run again
+func TagsToCodes(ctx context.Context) (posts int, users int, err error) {
+	err = mongoDB.FindEach(ctx, repository.PostsColl, bson.D{}, func(post model.Post) error {
+		locations := tags.ResolveLocations(post.Locations)
+		airlines := tags.ResolveAirlines(post.Airlines)
+		matches := slices.Clone(post.Matches)
+		for i, m := range matches {
+			if m.Kind == model.TagKindLocation {
+				matches[i].Tags = tags.ResolveLocations(m.Tags)
+			} else {
+				matches[i].Tags = tags.ResolveAirlines(m.Tags)
+			}
+		}
+
+		if sameSet(locations, post.Locations) && sameSet(airlines, post.Airlines) && sameMatches(matches, post.Matches) {
+			return nil
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "locations", Value: locations},
+			{Key: "airlines", Value: airlines},
+			{Key: "matches", Value: matches},
+		}}}
+		if _, err := mongoDB.UpdateById(ctx, repository.PostsColl, post.ID, update); err != nil {
+			return err
+		}
+		posts++
+		return nil
+	})
+	if err != nil {
+		return posts, users, errors.New("Cannot migrate posts: " + err.Error())
+	}
+
+	err = mongoDB.FindEach(ctx, repository.UsersColl, bson.D{}, func(user model.User) error {
+		locations := tags.ResolveLocations(user.SelectedLocations)
+		airlines := tags.ResolveAirlines(user.SelectedAirlines)
+		if sameSet(locations, user.SelectedLocations) && sameSet(airlines, user.SelectedAirlines) {
+			return nil
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "selected_locations", Value: locations},
+			{Key: "selected_airlines", Value: airlines},
+		}}}
+		if _, err := mongoDB.UpdateById(ctx, repository.UsersColl, user.ID, update); err != nil {
+			return err
+		}
+		users++
+		return nil
+	})
+	if err != nil {
+		return posts, users, errors.New("Cannot migrate users: " + err.Error())
+	}
+
+	return posts, users, nil
+}
+
+func sameSet(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func sameMatches(a []model.TagMatch, b []model.TagMatch) bool {
+	return slices.EqualFunc(a, b, func(x model.TagMatch, y model.TagMatch) bool {
+		return sameSet(x.Tags, y.Tags)
+	})
+}
+
+func tagsToCodes(ctx context.Context) error {
+	posts, users, err := TagsToCodes(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrated tags to codes on %d posts and %d users\n", posts, users)
+	return nil
+}
+
+func seedMissingTagFields(ctx context.Context) error {
+	entries, err := dictionary.SeedMissing(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added context rules, airline metadata, ambiguous codes and SC names to %d tag entries\n", entries)
+	return nil
+}
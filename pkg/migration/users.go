@@ -0,0 +1,36 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/google/uuid"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// backfillTelegramUIDs gives the users signed up before Telegram notifications the UID that links their
+// chat, as new users get on sign up. Telegram UIDs are unique from then on
+func backfillTelegramUIDs(ctx context.Context) error {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "telegram_uid", Value: bson.M{"$exists": false}}},
+		bson.D{{Key: "telegram_uid", Value: ""}},
+	}}}
+	users := 0
+	err := mongoDB.FindEach(ctx, repository.UsersColl, filter, func(user model.User) error {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "telegram_uid", Value: uuid.New().String()}}}}
+		if _, err := mongoDB.UpdateById(ctx, repository.UsersColl, user.ID, update); err != nil {
+			return err
+		}
+		users++
+		return nil
+	})
+	if err != nil {
+		return errors.New("Cannot backfill Telegram UIDs: " + err.Error())
+	}
+	fmt.Printf("Gave a Telegram UID to %d users\n", users)
+	return nil
+}
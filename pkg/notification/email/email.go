@@ -1,5 +1,7 @@
 package email
 
+import "context"
+
 type EmailAccount struct {
 	Name    string
 	Address string
@@ -13,7 +15,7 @@ type Content struct {
 // TODO: implement the notifier interface
 type Agent interface {
 	Sender() EmailAccount
-	Send(to EmailAccount, subject string, content Content) error
+	Send(ctx context.Context, to EmailAccount, subject string, content Content) error
 }
 
 // func Send() error {
@@ -1,6 +1,7 @@
 package sendgrid
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -27,14 +28,17 @@ func (sg SendGridAgent) Sender() email.EmailAccount {
 	return sg.sender
 }
 
-func (sg SendGridAgent) Send(to email.EmailAccount, subject string, content email.Content) error {
+func (sg SendGridAgent) Send(ctx context.Context, to email.EmailAccount, subject string, content email.Content) error {
 	sender := sg.Sender()
 	from := mail.NewEmail(sender.Name, sender.Address)
 	target := mail.NewEmail(to.Name, to.Address)
 	message := mail.NewSingleEmail(from, subject, target, content.PlainText, content.Html)
 
+	ctx, cancel := context.WithTimeout(ctx, config.Cfg.Email.Timeout)
+	defer cancel()
+
 	client := sendgrid.NewSendClient(config.Cfg.Email.SendGridAPIKey)
-	res, err := client.Send(message)
+	res, err := client.SendWithContext(ctx, message)
 	if err != nil {
 		log.Println(err)
 		return errors.New("Error sending email (SendGrid)" + err.Error())
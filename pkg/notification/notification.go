@@ -1,14 +1,16 @@
 package notification
 
 import (
+	"context"
+
 	model "github.com/jeffyfung/flight-info-agg/models"
 )
 
 type (
 	Notifier interface {
-		SetUp() error
-		Notify(user model.User, text string) error
-		NotifyChat(chatID int64, text string) error
+		SetUp(ctx context.Context) error
+		Notify(ctx context.Context, user model.User, text string) error
+		NotifyChat(ctx context.Context, chatID int64, text string) error
 		FormatAlertMessages(user model.User, posts []model.Post) string
 	}
 )
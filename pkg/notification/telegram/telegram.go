@@ -1,7 +1,9 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/go-errors/errors"
@@ -9,7 +11,9 @@ import (
 	"github.com/jeffyfung/flight-info-agg/config"
 	model "github.com/jeffyfung/flight-info-agg/models"
 	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
 	"github.com/jeffyfung/flight-info-agg/pkg/notification"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
 )
 
 type (
@@ -53,7 +57,10 @@ func NewNotifier() notification.Notifier {
 	return &TelegramNotifier{}
 }
 
-func (t *TelegramNotifier) SetUp() error {
+func (t *TelegramNotifier) SetUp(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Cfg.Telegram.Timeout)
+	defer cancel()
+
 	// set server url depending on whether it's prod
 	var callbackURL string
 	if !config.Cfg.Prod {
@@ -64,6 +71,7 @@ func (t *TelegramNotifier) SetUp() error {
 
 	// set webhook
 	resp, err := resty.New().R().
+		SetContext(ctx).
 		SetQueryParams(map[string]string{
 			"url": callbackURL,
 		}).
@@ -80,15 +88,19 @@ func (t *TelegramNotifier) SetUp() error {
 	return nil
 }
 
-func (t *TelegramNotifier) Notify(user model.User, text string) error {
+func (t *TelegramNotifier) Notify(ctx context.Context, user model.User, text string) error {
 	if user.TelegramChatID == 0 {
 		return errors.New("Cannot send Telegram message: no Telegram chat ID found")
 	}
-	return t.NotifyChat(user.TelegramChatID, text)
+	return t.NotifyChat(ctx, user.TelegramChatID, text)
 }
 
-func (t *TelegramNotifier) NotifyChat(chatID int64, text string) error {
+func (t *TelegramNotifier) NotifyChat(ctx context.Context, chatID int64, text string) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Cfg.Telegram.Timeout)
+	defer cancel()
+
 	_, err := resty.New().R().
+		SetContext(ctx).
 		SetBody(map[string]any{
 			"chat_id": chatID,
 			"text":    text,
@@ -103,21 +115,29 @@ func (t *TelegramNotifier) NotifyChat(chatID int64, text string) error {
 
 func (t *TelegramNotifier) FormatAlertMessages(user model.User, posts []model.Post) string {
 	p := collection.Map(posts, func(post model.Post) string {
-		return post.Title + "\n" + post.URL
+		explanation := explainMatch(user, post)
+		if explanation == "" {
+			return post.Title + "\n" + post.URL
+		}
+		return post.Title + "\n" + explanation + "\n" + post.URL
 	})
 
 	var selectedLocs string
 	if len(user.SelectedLocations) == 0 {
 		selectedLocs = "All"
 	} else {
-		selectedLocs = strings.Join(user.SelectedLocations, ", ")
+		selectedLocs = strings.Join(collection.Map(user.SelectedLocations, func(code string) string {
+			return tags.PlaceName(code, languages.TC)
+		}), ", ")
 	}
 
 	var selectedAirlines string
 	if len(user.SelectedAirlines) == 0 {
 		selectedAirlines = "All"
 	} else {
-		selectedAirlines = strings.Join(user.SelectedAirlines, ", ")
+		selectedAirlines = strings.Join(collection.Map(user.SelectedAirlines, func(code string) string {
+			return tags.AirlineName(code, languages.TC)
+		}), ", ")
 	}
 
 	formatted := fmt.Sprintf(`
@@ -132,3 +152,26 @@ Posts:
 
 	return formatted
 }
+
+// lists the matches that made the post relevant to the user, e.g. "matched: 台北 → 台灣"
+func explainMatch(user model.User, post model.Post) string {
+	explanations := []string{}
+	for _, m := range post.Matches {
+		var selected []string
+		if m.Kind == model.TagKindLocation {
+			selected = tags.WithDescendants(user.SelectedLocations)
+		} else {
+			selected = tags.ExpandAirlines(user.SelectedAirlines)
+		}
+		if len(selected) > 0 && !collection.HaveOverlap[string](m.Tags, selected) {
+			continue
+		}
+		explanations = append(explanations, tags.Explain(m))
+	}
+	explanations = collection.RemoveListDuplicates[string](explanations)
+	if len(explanations) == 0 {
+		return ""
+	}
+	sort.Strings(explanations)
+	return "matched: " + strings.Join(explanations, "; ")
+}
@@ -0,0 +1,245 @@
+package report
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+const (
+	defaultDays     = 30
+	defaultLimit    = 50
+	defaultMinCount = 3
+	maxExamples     = 3
+	minGram         = 2
+	maxGram         = 4
+)
+
+const (
+	ReasonNoLocations = "no locations"
+	ReasonNoAirlines  = "no airlines"
+	// every tag of the post comes from an alias, which is where wrong tags usually come from
+	ReasonAliasesOnly = "aliases only"
+)
+
+var (
+	// words around a place in deal titles, e.g. 飛清邁, 清邁來回
+	cuesBefore = []string{"飛", "往", "去", "直飛", "直航", "經", "遊"}
+	cuesAfter  = []string{"來回", "單程", "機票", "往返", "直航", "航線", "自由行", "團", "之旅"}
+	// endings of place names, e.g. 長灘島, 胡志明市
+	placeSuffixes = []rune("島市省州縣港灣城山湖")
+	// frequent in titles and summaries but never a destination. 香港 is the origin of every deal
+	stopWords = []string{
+		"香港", "機票", "來回", "單程", "往返", "優惠", "特價", "減價", "限時", "航空", "航班", "航點", "航線", "直航",
+		"直飛", "轉機", "經濟艙", "商務艙", "頭等艙", "套票", "酒店", "自由行", "多個", "開航", "連稅", "起",
+	}
+)
+
+type (
+	Options struct {
+		Days     int `json:"days" query:"days"`           // posts created in the last days
+		Limit    int `json:"limit" query:"limit"`         // of posts and of suggestions listed
+		MinCount int `json:"min_count" query:"min_count"` // posts an n-gram must appear in to be suggested
+	}
+
+	Post struct {
+		ID        string           `json:"id"`
+		Title     string           `json:"title"`
+		URL       string           `json:"url"`
+		Source    model.DataSource `json:"source"`
+		Locations []string         `json:"locations"`
+		Airlines  []string         `json:"airlines"`
+		Reasons   []string         `json:"reasons"`
+	}
+
+	// Suggestion is a place-like text that no dictionary entry matches, with the number of posts it appears in
+	Suggestion struct {
+		Text     string   `json:"text"`
+		Count    int      `json:"count"`
+		Examples []string `json:"examples"`
+	}
+
+	Report struct {
+		Since       time.Time    `json:"since"`
+		Scanned     int          `json:"scanned"`
+		NoLocations int          `json:"no_locations"`
+		NoAirlines  int          `json:"no_airlines"`
+		AliasesOnly int          `json:"aliases_only"`
+		Posts       []Post       `json:"posts"`
+		Suggestions []Suggestion `json:"suggestions"`
+	}
+)
+
+// Build reports the recent posts with missing or weak tags, and the unmatched n-grams of their titles and
+// summaries that look like places, as candidates for new aliases
+func Build(ctx context.Context, opts Options) (Report, error) {
+	if opts.Days <= 0 {
+		opts.Days = defaultDays
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultLimit
+	}
+	if opts.MinCount <= 0 {
+		opts.MinCount = defaultMinCount
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -opts.Days)
+	report := Report{Since: since, Posts: []Post{}, Suggestions: []Suggestion{}}
+	grams := map[string]*Suggestion{}
+
+	err := repository.Posts.Each(ctx, repository.PostFilter{CreatedSince: since}, func(post model.Post) error {
+		report.Scanned++
+
+		reasons := reasonsOf(post)
+		for _, reason := range reasons {
+			switch reason {
+			case ReasonNoLocations:
+				report.NoLocations++
+			case ReasonNoAirlines:
+				report.NoAirlines++
+			case ReasonAliasesOnly:
+				report.AliasesOnly++
+			}
+		}
+		if len(reasons) > 0 && len(report.Posts) < opts.Limit {
+			report.Posts = append(report.Posts, Post{
+				ID:        post.ID.Hex(),
+				Title:     post.Title,
+				URL:       post.URL,
+				Source:    post.Source,
+				Locations: post.Locations,
+				Airlines:  post.Airlines,
+				Reasons:   reasons,
+			})
+		}
+
+		postGrams := append(unmatchedGrams(model.FieldTitle, post.Title), unmatchedGrams(model.FieldSummary, post.Summary)...)
+		slices.Sort(postGrams)
+		for _, gram := range slices.Compact(postGrams) {
+			s, ok := grams[gram]
+			if !ok {
+				s = &Suggestion{Text: gram, Examples: []string{}}
+				grams[gram] = s
+			}
+			s.Count++
+			if len(s.Examples) < maxExamples {
+				s.Examples = append(s.Examples, post.Title)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, errors.New("Cannot scan posts: " + err.Error())
+	}
+
+	report.Suggestions = suggestions(grams, opts.MinCount, opts.Limit)
+	return report, nil
+}
+
+func reasonsOf(post model.Post) []string {
+	reasons := []string{}
+	if len(post.Locations) == 0 {
+		reasons = append(reasons, ReasonNoLocations)
+	}
+	if len(post.Airlines) == 0 {
+		reasons = append(reasons, ReasonNoAirlines)
+	}
+	if len(post.Matches) > 0 && !slices.ContainsFunc(post.Matches, func(m model.TagMatch) bool { return !m.Alias }) {
+		reasons = append(reasons, ReasonAliasesOnly)
+	}
+	return reasons
+}
+
+// unmatchedGrams returns the place-like n-grams of s that lie outside any text matched by the dictionaries
+func unmatchedGrams(field string, s string) []string {
+	text := []rune(s)
+	matched := make([]bool, len(text))
+	for _, m := range append(tags.ExtractDestinations(field, s), tags.ExtractAirlines(field, s)...) {
+		// only the first occurrence of a text is reported, so mask every occurrence
+		mText := []rune(m.Text)
+		for i := m.Offset; i+len(mText) <= len(text); i++ {
+			if string(text[i:i+len(mText)]) == m.Text {
+				for j := i; j < i+len(mText); j++ {
+					matched[j] = true
+				}
+			}
+		}
+	}
+
+	grams := []string{}
+	for start := 0; start < len(text); {
+		if matched[start] || !unicode.Is(unicode.Han, text[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(text) && !matched[end] && unicode.Is(unicode.Han, text[end]) {
+			end++
+		}
+		for i := start; i < end; i++ {
+			for n := minGram; n <= maxGram && i+n <= end; n++ {
+				if gram := string(text[i : i+n]); placeLike(text, i, i+n) && !hasStopWord(gram) {
+					grams = append(grams, gram)
+				}
+			}
+		}
+		start = end
+	}
+	return grams
+}
+
+// tells whether text[start:end] reads like a place, from the words around it or its ending
+func placeLike(text []rune, start int, end int) bool {
+	if slices.Contains(placeSuffixes, text[end-1]) {
+		return true
+	}
+	before, after := string(text[:start]), string(text[end:])
+	return slices.ContainsFunc(cuesBefore, func(cue string) bool { return strings.HasSuffix(before, cue) }) ||
+		slices.ContainsFunc(cuesAfter, func(cue string) bool { return strings.HasPrefix(after, cue) })
+}
+
+func hasStopWord(gram string) bool {
+	return slices.ContainsFunc(stopWords, func(word string) bool { return strings.Contains(gram, word) }) ||
+		slices.ContainsFunc(cuesBefore, func(cue string) bool { return strings.HasPrefix(gram, cue) }) ||
+		slices.ContainsFunc(cuesAfter, func(cue string) bool { return strings.HasSuffix(gram, cue) })
+}
+
+// keeps the n-grams seen in enough posts, dropping those that only ever appear within a longer one,
+// e.g. 蘭卡威 rather than 蘭卡 and 卡威
+func suggestions(grams map[string]*Suggestion, minCount int, limit int) []Suggestion {
+	output := []Suggestion{}
+	for gram, s := range grams {
+		if s.Count < minCount {
+			continue
+		}
+		within := false
+		for other, o := range grams {
+			if len(other) > len(gram) && o.Count >= s.Count && strings.Contains(other, gram) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			output = append(output, *s)
+		}
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Count != output[j].Count {
+			return output[i].Count > output[j].Count
+		}
+		return output[i].Text < output[j].Text
+	})
+	if len(output) > limit {
+		output = output[:limit]
+	}
+	return output
+}
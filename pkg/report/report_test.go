@@ -0,0 +1,105 @@
+package report
+
+import (
+	"slices"
+	"testing"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+)
+
+func TestUnmatchedGrams(t *testing.T) {
+	cases := []struct {
+		title string
+		want  []string
+	}{
+		// cued by 飛 before and 來回 after
+		{"飛蘭卡威來回$1,999起", []string{"蘭卡", "蘭卡威", "蘭卡威來", "卡威"}},
+		{"蘭卡威自由行 3日2夜", []string{"蘭卡威", "卡威"}},
+		{"香港飛仙本那", []string{"仙本", "仙本那"}},
+		{"直飛沙巴亞庇", []string{"沙巴", "沙巴亞", "沙巴亞庇"}},
+		// ending as place names do
+		{"綠島 船票", []string{"綠島"}},
+		{"宿霧薄荷島 3天", []string{"薄荷島", "荷島"}},
+		// places of the dictionaries are masked
+		{"國泰 東京來回 $2,888", []string{}},
+		{"香港快運 飛清邁 限時優惠", []string{}},
+		{"優惠機票 飛大阪", []string{}},
+		// uncued, or cued across a space
+		{"亞航 吉隆坡 蘭卡威 單程", []string{}},
+		{"Peach 樂桃 限時減價", []string{}},
+	}
+	for _, c := range cases {
+		if got := unmatchedGrams(model.FieldTitle, c.title); !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.title, got, c.want)
+		}
+	}
+}
+
+func TestPlaceLike(t *testing.T) {
+	cases := []struct {
+		text string
+		gram string // found in the text
+		want bool
+	}{
+		{"飛蘭卡威來回", "蘭卡威", true},
+		{"飛蘭卡威來回", "蘭卡", true},
+		{"飛蘭卡威來回", "卡威", true},
+		{"蘭卡威之旅", "蘭卡威", true},
+		{"長灘島", "長灘島", true},
+		{"胡志明市", "胡志明市", true},
+		{"蘭卡威 單程", "蘭卡威", false},
+		{"限時減價", "減價", false},
+		{"直飛蘭卡威", "直飛", false},
+	}
+	for _, c := range cases {
+		text, gram := []rune(c.text), []rune(c.gram)
+		start := slices.IndexFunc(text, func(r rune) bool { return r == gram[0] })
+		if got := placeLike(text, start, start+len(gram)); got != c.want {
+			t.Errorf("%s in %s: got %v, want %v", c.gram, c.text, got, c.want)
+		}
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	titles := []string{
+		"飛蘭卡威來回$1,999起",
+		"蘭卡威自由行 3日2夜",
+		"直飛沙巴亞庇",
+		"國泰 東京來回 $2,888",
+	}
+	// as Build counts them, once per post
+	grams := map[string]*Suggestion{}
+	for _, title := range titles {
+		postGrams := unmatchedGrams(model.FieldTitle, title)
+		slices.Sort(postGrams)
+		for _, gram := range slices.Compact(postGrams) {
+			if _, ok := grams[gram]; !ok {
+				grams[gram] = &Suggestion{Text: gram, Examples: []string{}}
+			}
+			grams[gram].Count++
+			grams[gram].Examples = append(grams[gram].Examples, title)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		minCount int
+		limit    int
+		want     []string
+	}{
+		// 蘭卡 and 卡威 only appear within 蘭卡威
+		{"in enough posts", 2, 10, []string{"蘭卡威"}},
+		{"longest of each place, most frequent first", 1, 10, []string{"蘭卡威", "沙巴亞庇", "蘭卡威來"}},
+		{"limited", 1, 2, []string{"蘭卡威", "沙巴亞庇"}},
+		{"in too few posts", 3, 10, []string{}},
+	}
+	for _, c := range cases {
+		got := []string{}
+		for _, s := range suggestions(grams, c.minCount, c.limit) {
+			got = append(got, s.Text)
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
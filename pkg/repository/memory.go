@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	MemoryPosts struct {
+		mu       sync.RWMutex
+		posts    []model.Post
+		archived []model.Post
+	}
+
+	MemoryUsers struct {
+		mu    sync.RWMutex
+		users map[string]model.User
+	}
+
+	MemorySystem struct {
+		mu          sync.RWMutex
+		watermarks  model.Watermarks
+		dictVersion int64
+	}
+)
+
+func NewMemoryPosts() *MemoryPosts {
+	return &MemoryPosts{posts: []model.Post{}, archived: []model.Post{}}
+}
+
+// AddArchived adds posts as if moved to the archive, e.g. to check queries including them
+func (m *MemoryPosts) AddArchived(posts ...model.Post) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.archived = append(m.archived, withIDs(posts)...)
+}
+
+func (m *MemoryPosts) matching(f PostFilter) []model.Post {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := m.posts
+	if f.IncludeArchived {
+		all = append(slices.Clone(m.posts), m.archived...)
+	}
+
+	output := []model.Post{}
+	for _, post := range all {
+		if len(f.Locations) > 0 && !overlaps(post.Locations, f.Locations) {
+			continue
+		}
+		if len(f.Airlines) > 0 && !overlaps(post.Airlines, f.Airlines) {
+			continue
+		}
+		if len(f.Sources) > 0 && !slices.Contains(f.Sources, post.Source) {
+			continue
+		}
+		if post.CreatedAt.Before(f.CreatedSince) {
+			continue
+		}
+		if post.PubDate.Before(f.PublishedFrom) || (!f.PublishedTo.IsZero() && !post.PubDate.Before(f.PublishedTo)) {
+			continue
+		}
+		if f.TaggedBefore > 0 && post.DictVersion >= f.TaggedBefore {
+			continue
+		}
+		output = append(output, post)
+	}
+	return output
+}
+
+func (m *MemoryPosts) Find(_ context.Context, f PostFilter) ([]model.Post, error) {
+	posts := m.matching(f)
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].PubDate.After(posts[j].PubDate)
+	})
+	if f.Limit > 0 {
+		posts = posts[:min(int64(len(posts)), f.Limit)]
+	}
+	return posts, nil
+}
+
+func (m *MemoryPosts) Each(_ context.Context, f PostFilter, fn func(model.Post) error) error {
+	if f.IncludeArchived {
+		return errors.WrapPrefix(ErrArchivedUnsupported, "Cannot stream posts", 0)
+	}
+	posts := m.matching(f)
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].CreatedAt.After(posts[j].CreatedAt)
+	})
+	for _, post := range posts {
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPosts) Page(_ context.Context, f PostFilter, after primitive.ObjectID, n int64) ([]model.Post, error) {
+	posts := slices.DeleteFunc(m.matching(f), func(post model.Post) bool {
+		return bytes.Compare(post.ID[:], after[:]) <= 0
+	})
+	slices.SortFunc(posts, byID)
+	return posts[:min(int64(len(posts)), n)], nil
+}
+
+func (m *MemoryPosts) StoredURLs(_ context.Context, urls []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stored := map[string]bool{}
+	for _, posts := range [][]model.Post{m.posts, m.archived} {
+		for _, post := range posts {
+			if slices.Contains(urls, post.URL) {
+				stored[post.URL] = true
+			}
+		}
+	}
+	return stored, nil
+}
+
+func (m *MemoryPosts) Insert(_ context.Context, posts []model.Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.posts = append(m.posts, withIDs(posts)...)
+	return nil
+}
+
+func (m *MemoryPosts) SetTags(_ context.Context, post model.Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.posts {
+		if m.posts[i].ID == post.ID {
+			m.posts[i].Locations = post.Locations
+			m.posts[i].Airlines = post.Airlines
+			m.posts[i].Matches = post.Matches
+			m.posts[i].DictVersion = post.DictVersion
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPosts) Archive(_ context.Context, f ArchiveFilter, at time.Time, dryRun bool) (map[model.DataSource]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	archived := map[model.DataSource]int64{}
+	live := []model.Post{}
+	for _, post := range m.posts {
+		expired := post.PubDate.Before(f.PublishedBefore)
+		if len(f.Sources) > 0 {
+			expired = expired && slices.Contains(f.Sources, post.Source)
+		} else {
+			expired = expired && !slices.Contains(f.ExceptSources, post.Source)
+		}
+		if !expired {
+			live = append(live, post)
+			continue
+		}
+		archived[post.Source]++
+		if !dryRun {
+			post.ArchivedAt = &at
+			m.archived = append(m.archived, post)
+		}
+	}
+	if !dryRun {
+		m.posts = live
+	}
+	return archived, nil
+}
+
+// withIDs sets the IDs MongoDB would set on insert
+func withIDs(posts []model.Post) []model.Post {
+	output := slices.Clone(posts)
+	for i := range output {
+		if output[i].ID.IsZero() {
+			output[i].ID = primitive.NewObjectID()
+		}
+	}
+	return output
+}
+
+func overlaps(a []string, b []string) bool {
+	return slices.ContainsFunc(a, func(s string) bool { return slices.Contains(b, s) })
+}
+
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{users: map[string]model.User{}}
+}
+
+func (m *MemoryUsers) Get(_ context.Context, id string) (model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[id]
+	if !ok {
+		return user, errors.WrapPrefix(ErrNotFound, "user "+id, 0)
+	}
+	return user, nil
+}
+
+func (m *MemoryUsers) ByTelegramUID(_ context.Context, uid string) (model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.TelegramUID == uid {
+			return user, nil
+		}
+	}
+	return model.User{}, errors.WrapPrefix(ErrNotFound, "user of Telegram UID "+uid, 0)
+}
+
+func (m *MemoryUsers) Insert(_ context.Context, user model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[user.ID]; ok {
+		return errors.New("Cannot insert user " + user.ID + ": duplicate ID")
+	}
+	m.users[user.ID] = user
+	return nil
+}
+
+func (m *MemoryUsers) Upsert(_ context.Context, user model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[user.ID] = user
+	return nil
+}
+
+func (m *MemoryUsers) SetLastLogin(_ context.Context, id string, at time.Time) error {
+	return m.update(id, func(user *model.User) {
+		user.LastLogin = &at
+	})
+}
+
+func (m *MemoryUsers) SetSubscription(_ context.Context, id string, query model.Query, notification model.Notification) error {
+	return m.update(id, func(user *model.User) {
+		now := time.Now().UTC()
+		user.LastUpdated = &now
+		user.Query = query
+		user.Notification = notification
+	})
+}
+
+// update leaves unknown IDs alone, as updates by ID on MongoDB do
+func (m *MemoryUsers) update(id string, fn func(*model.User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[id]; ok {
+		fn(&user)
+		m.users[id] = user
+	}
+	return nil
+}
+
+func (m *MemoryUsers) EachSubscriber(_ context.Context, ids []string, fn func(model.User) error) error {
+	m.mu.RLock()
+	users := []model.User{}
+	for _, user := range m.users {
+		if (len(ids) == 0 && user.Notification == model.NotificationOn) || slices.Contains(ids, user.ID) {
+			users = append(users, user)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewMemorySystem() *MemorySystem {
+	return &MemorySystem{watermarks: model.Watermarks{Sources: map[string]time.Time{}}}
+}
+
+func (m *MemorySystem) Watermarks(_ context.Context) (model.Watermarks, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	output := model.Watermarks{LastUpdated: m.watermarks.LastUpdated, Sources: map[string]time.Time{}}
+	for source, at := range m.watermarks.Sources {
+		output.Sources[source] = at
+	}
+	return output, nil
+}
+
+func (m *MemorySystem) SetWatermarks(_ context.Context, sources []model.DataSource, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watermarks.LastUpdated = at
+	for _, source := range sources {
+		m.watermarks.Sources[string(source)] = at
+	}
+	return nil
+}
+
+func (m *MemorySystem) DictVersion(_ context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.dictVersion, nil
+}
+
+func (m *MemorySystem) BumpDictVersion(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dictVersion++
+	return nil
+}
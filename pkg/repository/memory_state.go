@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	MemoryTags struct {
+		mu      sync.RWMutex
+		entries map[string]model.TagEntry
+	}
+
+	MemorySpecs struct {
+		mu    sync.RWMutex
+		specs map[model.DataSource]model.SourceSpec
+	}
+
+	MemoryHealth struct {
+		mu     sync.RWMutex
+		runs   []model.SourceRun
+		health map[model.DataSource]model.SourceHealth
+	}
+
+	MemoryJobs struct {
+		mu   sync.Mutex
+		jobs map[string]model.JobState
+	}
+
+	MemoryLocks struct {
+		mu     sync.Mutex
+		leases map[string]model.Lease
+	}
+
+	MemoryMigrations struct {
+		mu      sync.RWMutex
+		records map[int]model.AppliedMigration
+	}
+)
+
+// sortedValues returns the values of the map by key
+func sortedValues[K cmp.Ordered, V any](m map[K]V) []V {
+	keys := []K{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	output := []V{}
+	for _, key := range keys {
+		output = append(output, m[key])
+	}
+	return output
+}
+
+func NewMemoryTags() *MemoryTags {
+	return &MemoryTags{entries: map[string]model.TagEntry{}}
+}
+
+func (m *MemoryTags) List(_ context.Context) ([]model.TagEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedValues(m.entries), nil
+}
+
+func (m *MemoryTags) Insert(_ context.Context, entry model.TagEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[entry.ID]; ok {
+		return errors.New("Cannot insert tag entry " + entry.ID + ": duplicate ID")
+	}
+	m.entries[entry.ID] = entry
+	return nil
+}
+
+func (m *MemoryTags) Save(_ context.Context, entry model.TagEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.ID] = entry
+	return nil
+}
+
+func (m *MemoryTags) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+	return nil
+}
+
+func NewMemorySpecs() *MemorySpecs {
+	return &MemorySpecs{specs: map[model.DataSource]model.SourceSpec{}}
+}
+
+func (m *MemorySpecs) List(_ context.Context) ([]model.SourceSpec, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedValues(m.specs), nil
+}
+
+func (m *MemorySpecs) Save(_ context.Context, spec model.SourceSpec) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.specs[spec.Name] = spec
+	return nil
+}
+
+func NewMemoryHealth() *MemoryHealth {
+	return &MemoryHealth{runs: []model.SourceRun{}, health: map[model.DataSource]model.SourceHealth{}}
+}
+
+func (m *MemoryHealth) Runs(_ context.Context, source model.DataSource, n int64) ([]model.SourceRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	runs := slices.DeleteFunc(slices.Clone(m.runs), func(run model.SourceRun) bool {
+		return run.Source != source
+	})
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].At.After(runs[j].At)
+	})
+	return runs[:min(int64(len(runs)), n)], nil
+}
+
+func (m *MemoryHealth) InsertRun(_ context.Context, run model.SourceRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *MemoryHealth) Get(_ context.Context, source model.DataSource) (model.SourceHealth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	health, ok := m.health[source]
+	if !ok {
+		return health, errors.WrapPrefix(ErrNotFound, "health of "+string(source), 0)
+	}
+	return health, nil
+}
+
+func (m *MemoryHealth) Save(_ context.Context, health model.SourceHealth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health[health.Source] = health
+	return nil
+}
+
+func (m *MemoryHealth) List(_ context.Context) ([]model.SourceHealth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedValues(m.health), nil
+}
+
+func NewMemoryJobs() *MemoryJobs {
+	return &MemoryJobs{jobs: map[string]model.JobState{}}
+}
+
+func (m *MemoryJobs) List(_ context.Context) ([]model.JobState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedValues(m.jobs), nil
+}
+
+func (m *MemoryJobs) Get(_ context.Context, name string) (model.JobState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[name]
+	if !ok {
+		return job, errors.WrapPrefix(ErrNotFound, "job "+name, 0)
+	}
+	return job, nil
+}
+
+func (m *MemoryJobs) SetSchedule(_ context.Context, name string, schedule string, next time.Time) error {
+	m.upsert(name, func(job *model.JobState) {
+		job.Schedule, job.NextRun = schedule, &next
+	})
+	return nil
+}
+
+func (m *MemoryJobs) SetLastRun(_ context.Context, name string, at time.Time, duration time.Duration, lastError string, result string) error {
+	m.upsert(name, func(job *model.JobState) {
+		job.LastRun, job.LastDuration, job.LastError, job.LastResult = &at, duration.Milliseconds(), lastError, result
+	})
+	return nil
+}
+
+func (m *MemoryJobs) upsert(name string, fn func(*model.JobState)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[name]
+	if !ok {
+		job = model.JobState{Name: name}
+	}
+	fn(&job)
+	m.jobs[name] = job
+}
+
+func (m *MemoryJobs) ClaimSlot(_ context.Context, name string, slot time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[name]
+	if !ok || (job.ClaimedSlot != nil && !job.ClaimedSlot.Before(slot)) {
+		return false, nil
+	}
+	job.ClaimedSlot = &slot
+	m.jobs[name] = job
+	return true, nil
+}
+
+func (m *MemoryJobs) Trigger(_ context.Context, name string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[name]; ok {
+		job.TriggeredAt = &at
+		m.jobs[name] = job
+	}
+	return nil
+}
+
+func (m *MemoryJobs) Triggered(_ context.Context) ([]model.JobState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.DeleteFunc(sortedValues(m.jobs), func(job model.JobState) bool {
+		return job.TriggeredAt == nil
+	}), nil
+}
+
+func (m *MemoryJobs) ClearTrigger(_ context.Context, name string, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[name]
+	if !ok || job.TriggeredAt == nil || !job.TriggeredAt.Equal(at) {
+		return false, nil
+	}
+	job.TriggeredAt = nil
+	m.jobs[name] = job
+	return true, nil
+}
+
+func NewMemoryLocks() *MemoryLocks {
+	return &MemoryLocks{leases: map[string]model.Lease{}}
+}
+
+func (m *MemoryLocks) Acquire(_ context.Context, lease model.Lease) (model.Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.leases[lease.Name]; ok && !current.ExpiresAt.Before(lease.AcquiredAt) {
+		return current, nil
+	}
+	m.leases[lease.Name] = lease
+	return lease, nil
+}
+
+func (m *MemoryLocks) Extend(_ context.Context, name string, holder string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lease, ok := m.leases[name]
+	if !ok || lease.Holder != holder {
+		return false, nil
+	}
+	lease.ExpiresAt = until
+	m.leases[name] = lease
+	return true, nil
+}
+
+func (m *MemoryLocks) Release(_ context.Context, name string, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lease, ok := m.leases[name]; ok && lease.Holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func (m *MemoryLocks) Held(_ context.Context, names []string, at time.Time) (map[string]model.Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held := map[string]model.Lease{}
+	for _, name := range names {
+		if lease, ok := m.leases[name]; ok && !lease.ExpiresAt.Before(at) {
+			held[name] = lease
+		}
+	}
+	return held, nil
+}
+
+func NewMemoryMigrations() *MemoryMigrations {
+	return &MemoryMigrations{records: map[int]model.AppliedMigration{}}
+}
+
+func (m *MemoryMigrations) Applied(_ context.Context) ([]model.AppliedMigration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedValues(m.records), nil
+}
+
+func (m *MemoryMigrations) Record(_ context.Context, record model.AppliedMigration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.records[record.Version]; ok {
+		return errors.New(fmt.Sprintf("Cannot record migration %d %s: duplicate version", record.Version, record.Name))
+	}
+	m.records[record.Version] = record
+	return nil
+}
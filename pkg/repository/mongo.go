@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collections of the posts and users, also migrated by pkg/migration
+const (
+	PostsColl = "posts"
+	UsersColl = "users"
+	// expired posts are moved there with their _id, and kept for price trends and seasonal analysis
+	ArchiveColl = "posts_archive"
+)
+
+const (
+	systemColl = "system"
+
+	archiveBatchSize = 500
+
+	// documents of the system collection
+	scrapperDoc = "scrapper"
+	tagsDoc     = "tags"
+)
+
+type (
+	mongoPosts  struct{}
+	mongoUsers  struct{}
+	mongoSystem struct{}
+)
+
+func postFilterOf(f PostFilter) bson.D {
+	filter := bson.D{}
+	if len(f.Locations) > 0 {
+		filter = append(filter, bson.E{Key: "locations", Value: bson.M{"$in": f.Locations}})
+	}
+	if len(f.Airlines) > 0 {
+		filter = append(filter, bson.E{Key: "airlines", Value: bson.M{"$in": f.Airlines}})
+	}
+	if len(f.Sources) > 0 {
+		filter = append(filter, bson.E{Key: "source", Value: bson.M{"$in": f.Sources}})
+	}
+	if !f.CreatedSince.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at", Value: bson.M{"$gte": f.CreatedSince}})
+	}
+	published := bson.M{}
+	if !f.PublishedFrom.IsZero() {
+		published["$gte"] = f.PublishedFrom
+	}
+	if !f.PublishedTo.IsZero() {
+		published["$lt"] = f.PublishedTo
+	}
+	if len(published) > 0 {
+		filter = append(filter, bson.E{Key: "pub_date", Value: published})
+	}
+	if f.TaggedBefore > 0 {
+		// also matches posts without a version
+		filter = append(filter, bson.E{Key: "dict_version", Value: bson.M{"$not": bson.M{"$gte": f.TaggedBefore}}})
+	}
+	return filter
+}
+
+func postColls(f PostFilter) []string {
+	if f.IncludeArchived {
+		return []string{PostsColl, ArchiveColl}
+	}
+	return []string{PostsColl}
+}
+
+func (mongoPosts) Find(ctx context.Context, f PostFilter) ([]model.Post, error) {
+	sort := mongoDB.SortOption{SortKey: "pub_date", Order: -1}
+	output := []model.Post{}
+	for _, coll := range postColls(f) {
+		// no limit when zero
+		posts, err := mongoDB.FindPage[model.Post](ctx, coll, postFilterOf(f), f.Limit, sort)
+		if err != nil {
+			return nil, errors.New("Cannot find posts: " + err.Error())
+		}
+		output = append(output, posts...)
+	}
+	if f.IncludeArchived {
+		slices.SortStableFunc(output, func(a, b model.Post) int {
+			return b.PubDate.Compare(a.PubDate)
+		})
+	}
+	if f.Limit > 0 {
+		output = output[:min(int64(len(output)), f.Limit)]
+	}
+	return output, nil
+}
+
+func (mongoPosts) Each(ctx context.Context, f PostFilter, fn func(model.Post) error) error {
+	// the posts and the archive would be streamed one after the other, out of order
+	if f.IncludeArchived {
+		return errors.WrapPrefix(ErrArchivedUnsupported, "Cannot stream posts", 0)
+	}
+	return mongoDB.FindEach(ctx, PostsColl, postFilterOf(f), fn, mongoDB.SortOption{SortKey: "created_at", Order: -1})
+}
+
+func (mongoPosts) Page(ctx context.Context, f PostFilter, after primitive.ObjectID, n int64) ([]model.Post, error) {
+	filter := postFilterOf(f)
+	if !after.IsZero() {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$gt": after}})
+	}
+	output := []model.Post{}
+	for _, coll := range postColls(f) {
+		posts, err := mongoDB.FindPage[model.Post](ctx, coll, filter, n, mongoDB.SortOption{SortKey: "_id", Order: 1})
+		if err != nil {
+			return nil, errors.New("Cannot find posts: " + err.Error())
+		}
+		output = append(output, posts...)
+	}
+	// the pages of the posts and of the archive are merged
+	slices.SortFunc(output, byID)
+	return output[:min(int64(len(output)), n)], nil
+}
+
+func byID(a, b model.Post) int {
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func (mongoPosts) StoredURLs(ctx context.Context, urls []string) (map[string]bool, error) {
+	stored := map[string]bool{}
+	for _, coll := range []string{PostsColl, ArchiveColl} {
+		posts, err := mongoDB.Find[model.Post](ctx, coll, bson.D{{Key: "url", Value: bson.M{"$in": urls}}})
+		if err != nil {
+			return nil, errors.New("Cannot get stored posts: " + err.Error())
+		}
+		for _, post := range posts {
+			stored[post.URL] = true
+		}
+	}
+	return stored, nil
+}
+
+func (mongoPosts) Insert(ctx context.Context, posts []model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	if _, err := mongoDB.InsertBulkToCollection(ctx, PostsColl, posts); err != nil {
+		return errors.New("Cannot insert to posts table: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoPosts) SetTags(ctx context.Context, post model.Post) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "locations", Value: post.Locations},
+		{Key: "airlines", Value: post.Airlines},
+		{Key: "matches", Value: post.Matches},
+		{Key: "dict_version", Value: post.DictVersion},
+	}}}
+	if _, err := mongoDB.UpdateById(ctx, PostsColl, post.ID, update); err != nil {
+		return errors.New("Cannot update post: " + err.Error())
+	}
+	return nil
+}
+
+// Archive moves the posts in batches. Posts are inserted before they are deleted, so that a run stopped
+// halfway loses nothing and the next one skips the posts already archived
+func (mongoPosts) Archive(ctx context.Context, f ArchiveFilter, at time.Time, dryRun bool) (map[model.DataSource]int64, error) {
+	archived := map[model.DataSource]int64{}
+	filter := bson.D{{Key: "pub_date", Value: bson.M{"$lt": f.PublishedBefore}}}
+	if len(f.Sources) > 0 {
+		filter = append(filter, bson.E{Key: "source", Value: bson.M{"$in": f.Sources}})
+	} else if len(f.ExceptSources) > 0 {
+		filter = append(filter, bson.E{Key: "source", Value: bson.M{"$nin": f.ExceptSources}})
+	}
+
+	if dryRun {
+		err := mongoDB.FindEach(ctx, PostsColl, filter, func(post model.Post) error {
+			archived[post.Source]++
+			return nil
+		})
+		return archived, err
+	}
+
+	for {
+		posts, err := mongoDB.FindPage[model.Post](ctx, PostsColl, filter, archiveBatchSize, mongoDB.SortOption{SortKey: "_id", Order: 1})
+		if err != nil {
+			return archived, errors.New("Cannot get expired posts: " + err.Error())
+		}
+		if len(posts) == 0 {
+			return archived, nil
+		}
+
+		ids := []any{}
+		for i := range posts {
+			posts[i].ArchivedAt = &at
+			ids = append(ids, posts[i].ID)
+		}
+		opts := options.InsertMany().SetOrdered(false)
+		_, err = mongoDB.InsertBulkToCollection(ctx, ArchiveColl, posts, opts)
+		if err != nil && !onlyDuplicates(err) {
+			return archived, errors.New("Cannot archive posts: " + err.Error())
+		}
+		if _, err = mongoDB.DeleteMany(ctx, PostsColl, bson.D{{Key: "_id", Value: bson.M{"$in": ids}}}); err != nil {
+			return archived, errors.New("Cannot delete archived posts: " + err.Error())
+		}
+
+		for _, post := range posts {
+			archived[post.Source]++
+		}
+		fmt.Printf("Archived %d posts\n", len(posts))
+	}
+}
+
+// onlyDuplicates tells whether the posts not inserted were all archived already
+func onlyDuplicates(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func (mongoUsers) Get(ctx context.Context, id string) (model.User, error) {
+	user, err := mongoDB.GetById[model.User](ctx, UsersColl, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, errors.WrapPrefix(ErrNotFound, "user "+id, 0)
+	}
+	return user, err
+}
+
+func (mongoUsers) ByTelegramUID(ctx context.Context, uid string) (model.User, error) {
+	users, err := mongoDB.Find[model.User](ctx, UsersColl, bson.D{{Key: "telegram_uid", Value: uid}})
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(users) == 0 {
+		return model.User{}, errors.WrapPrefix(ErrNotFound, "user of Telegram UID "+uid, 0)
+	}
+	return users[0], nil
+}
+
+func (mongoUsers) Insert(ctx context.Context, user model.User) error {
+	_, err := mongoDB.InsertToCollection(ctx, UsersColl, user)
+	return err
+}
+
+func (mongoUsers) Upsert(ctx context.Context, user model.User) error {
+	_, err := mongoDB.ReplaceByID(ctx, UsersColl, user.ID, user, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (mongoUsers) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	_, err := mongoDB.UpdateById(ctx, UsersColl, id, bson.D{{Key: "$set", Value: bson.D{{Key: "last_login", Value: &at}}}})
+	return err
+}
+
+func (mongoUsers) SetSubscription(ctx context.Context, id string, query model.Query, notification model.Notification) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "last_updated", Value: time.Now().UTC()},
+		{Key: "selected_locations", Value: query.SelectedLocations},
+		{Key: "selected_airlines", Value: query.SelectedAirlines},
+		{Key: "notification", Value: notification},
+	}}}
+	_, err := mongoDB.UpdateById(ctx, UsersColl, id, update)
+	return err
+}
+
+func (mongoUsers) EachSubscriber(ctx context.Context, ids []string, fn func(model.User) error) error {
+	filter := bson.D{{Key: "notification", Value: model.NotificationOn}}
+	if len(ids) > 0 {
+		filter = bson.D{{Key: "_id", Value: bson.M{"$in": ids}}}
+	}
+	return mongoDB.FindEach(ctx, UsersColl, filter, fn)
+}
+
+func (mongoSystem) Watermarks(ctx context.Context) (model.Watermarks, error) {
+	output, err := mongoDB.GetById[model.Watermarks](ctx, systemColl, scrapperDoc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return output, err
+	}
+	return output, nil
+}
+
+func (mongoSystem) SetWatermarks(ctx context.Context, sources []model.DataSource, at time.Time) error {
+	set := bson.D{{Key: "last_updated", Value: at}}
+	for _, source := range sources {
+		set = append(set, bson.E{Key: "sources." + string(source), Value: at})
+	}
+	update := bson.D{{Key: "$set", Value: set}}
+	_, err := mongoDB.UpdateById(ctx, systemColl, scrapperDoc, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (mongoSystem) DictVersion(ctx context.Context) (int64, error) {
+	type v = struct {
+		Version int64 `bson:"version"`
+	}
+	output, err := mongoDB.GetById[v](ctx, systemColl, tagsDoc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, err
+	}
+	return output.Version, nil
+}
+
+func (mongoSystem) BumpDictVersion(ctx context.Context) error {
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "last_updated", Value: time.Now().UTC()}}},
+	}
+	_, err := mongoDB.UpdateById(ctx, systemColl, tagsDoc, update, options.Update().SetUpsert(true))
+	return err
+}
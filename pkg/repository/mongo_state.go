@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collections of the state, also indexed by pkg/migration
+const (
+	RunsColl  = "source_runs"
+	JobsColl  = "jobs"
+	LocksColl = "locks"
+)
+
+const (
+	tagsColl       = "tags"
+	specsColl      = "source_specs"
+	healthColl     = "source_health"
+	migrationsColl = "migrations"
+)
+
+type (
+	mongoTags       struct{}
+	mongoSpecs      struct{}
+	mongoHealth     struct{}
+	mongoJobs       struct{}
+	mongoLocks      struct{}
+	mongoMigrations struct{}
+)
+
+func (mongoTags) List(ctx context.Context) ([]model.TagEntry, error) {
+	entries, err := mongoDB.Find[model.TagEntry](ctx, tagsColl, bson.D{}, mongoDB.SortOption{SortKey: "_id", Order: 1})
+	if err != nil {
+		return nil, errors.New("Cannot get tag entries: " + err.Error())
+	}
+	return entries, nil
+}
+
+func (mongoTags) Insert(ctx context.Context, entry model.TagEntry) error {
+	if _, err := mongoDB.InsertToCollection(ctx, tagsColl, entry); err != nil {
+		return errors.New("Cannot insert tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoTags) Save(ctx context.Context, entry model.TagEntry) error {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := mongoDB.ReplaceByID(ctx, tagsColl, entry.ID, entry, opts); err != nil {
+		return errors.New("Cannot save tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoTags) Delete(ctx context.Context, id string) error {
+	if _, err := mongoDB.DeleteMany(ctx, tagsColl, bson.D{{Key: "_id", Value: id}}); err != nil {
+		return errors.New("Cannot delete tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoSpecs) List(ctx context.Context) ([]model.SourceSpec, error) {
+	specs, err := mongoDB.Find[model.SourceSpec](ctx, specsColl, bson.D{}, mongoDB.SortOption{SortKey: "_id", Order: 1})
+	if err != nil {
+		return nil, errors.New("Cannot get source specs: " + err.Error())
+	}
+	return specs, nil
+}
+
+func (mongoSpecs) Save(ctx context.Context, spec model.SourceSpec) error {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := mongoDB.ReplaceByID(ctx, specsColl, string(spec.Name), spec, opts); err != nil {
+		return errors.New("Cannot save source spec: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoHealth) Runs(ctx context.Context, source model.DataSource, n int64) ([]model.SourceRun, error) {
+	filter := bson.D{{Key: "source", Value: source}}
+	runs, err := mongoDB.FindPage[model.SourceRun](ctx, RunsColl, filter, n, mongoDB.SortOption{SortKey: "at", Order: -1})
+	if err != nil {
+		return nil, errors.New("Cannot get source runs: " + err.Error())
+	}
+	return runs, nil
+}
+
+func (mongoHealth) InsertRun(ctx context.Context, run model.SourceRun) error {
+	if _, err := mongoDB.InsertToCollection(ctx, RunsColl, run); err != nil {
+		return errors.New("Cannot insert source run: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoHealth) Get(ctx context.Context, source model.DataSource) (model.SourceHealth, error) {
+	health, err := mongoDB.GetById[model.SourceHealth](ctx, healthColl, string(source))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return health, errors.WrapPrefix(ErrNotFound, "health of "+string(source), 0)
+	}
+	if err != nil {
+		return health, errors.New("Cannot get source health: " + err.Error())
+	}
+	return health, nil
+}
+
+func (mongoHealth) Save(ctx context.Context, health model.SourceHealth) error {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := mongoDB.ReplaceByID(ctx, healthColl, string(health.Source), health, opts); err != nil {
+		return errors.New("Cannot save source health: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoHealth) List(ctx context.Context) ([]model.SourceHealth, error) {
+	sources, err := mongoDB.Find[model.SourceHealth](ctx, healthColl, bson.D{}, mongoDB.SortOption{SortKey: "_id", Order: 1})
+	if err != nil {
+		return nil, errors.New("Cannot get source health: " + err.Error())
+	}
+	return sources, nil
+}
+
+func (mongoJobs) List(ctx context.Context) ([]model.JobState, error) {
+	jobs, err := mongoDB.Find[model.JobState](ctx, JobsColl, bson.D{}, mongoDB.SortOption{SortKey: "_id", Order: 1})
+	if err != nil {
+		return nil, errors.New("Cannot get jobs: " + err.Error())
+	}
+	return jobs, nil
+}
+
+func (mongoJobs) Get(ctx context.Context, name string) (model.JobState, error) {
+	job, err := mongoDB.GetById[model.JobState](ctx, JobsColl, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return job, errors.WrapPrefix(ErrNotFound, "job "+name, 0)
+	}
+	if err != nil {
+		return job, errors.New("Cannot get job: " + err.Error())
+	}
+	return job, nil
+}
+
+func (mongoJobs) SetSchedule(ctx context.Context, name string, schedule string, next time.Time) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "schedule", Value: schedule}, {Key: "next_run", Value: next}}}}
+	if _, err := mongoDB.UpdateById(ctx, JobsColl, name, update, options.Update().SetUpsert(true)); err != nil {
+		return errors.New("Cannot save schedule of job " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (mongoJobs) SetLastRun(ctx context.Context, name string, at time.Time, duration time.Duration, lastError string, result string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "last_run", Value: at},
+		{Key: "last_duration_ms", Value: duration.Milliseconds()},
+		{Key: "last_error", Value: lastError},
+		{Key: "last_result", Value: result},
+	}}}
+	if _, err := mongoDB.UpdateById(ctx, JobsColl, name, update, options.Update().SetUpsert(true)); err != nil {
+		return errors.New("Cannot save last run of job " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (mongoJobs) ClaimSlot(ctx context.Context, name string, slot time.Time) (bool, error) {
+	// also matches jobs never claimed
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "claimed_slot", Value: bson.M{"$not": bson.M{"$gte": slot}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "claimed_slot", Value: slot}}}}
+	result, err := mongoDB.UpdateOne(ctx, JobsColl, filter, update)
+	if err != nil {
+		return false, errors.New("Cannot claim slot of job " + name + ": " + err.Error())
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (mongoJobs) Trigger(ctx context.Context, name string, at time.Time) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "triggered_at", Value: at}}}}
+	if _, err := mongoDB.UpdateById(ctx, JobsColl, name, update); err != nil {
+		return errors.New("Cannot trigger job: " + err.Error())
+	}
+	return nil
+}
+
+func (mongoJobs) Triggered(ctx context.Context) ([]model.JobState, error) {
+	jobs, err := mongoDB.Find[model.JobState](ctx, JobsColl, bson.D{{Key: "triggered_at", Value: bson.M{"$ne": nil}}})
+	if err != nil {
+		return nil, errors.New("Cannot get triggered jobs: " + err.Error())
+	}
+	return jobs, nil
+}
+
+func (mongoJobs) ClearTrigger(ctx context.Context, name string, at time.Time) (bool, error) {
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "triggered_at", Value: at}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "triggered_at", Value: ""}}}}
+	result, err := mongoDB.UpdateOne(ctx, JobsColl, filter, update)
+	if err != nil {
+		return false, errors.New("Cannot clear trigger of job " + name + ": " + err.Error())
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (mongoLocks) Acquire(ctx context.Context, lease model.Lease) (model.Lease, error) {
+	filter := bson.D{{Key: "_id", Value: lease.Name}, {Key: "expires_at", Value: bson.M{"$lt": lease.AcquiredAt}}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "holder", Value: lease.Holder},
+		{Key: "acquired_at", Value: lease.AcquiredAt},
+		{Key: "expires_at", Value: lease.ExpiresAt},
+	}}}
+	// the upsert of a lease held by another conflicts with it on _id
+	_, err := mongoDB.UpdateOne(ctx, LocksColl, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		current, err := mongoDB.GetById[model.Lease](ctx, LocksColl, lease.Name)
+		// released since, by a holder other than the caller all the same
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return model.Lease{Name: lease.Name}, nil
+		}
+		if err != nil {
+			return current, errors.New("Cannot get lock " + lease.Name + ": " + err.Error())
+		}
+		return current, nil
+	}
+	if err != nil {
+		return lease, errors.New("Cannot acquire lock " + lease.Name + ": " + err.Error())
+	}
+	return lease, nil
+}
+
+func (mongoLocks) Extend(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "holder", Value: holder}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: until}}}}
+	result, err := mongoDB.UpdateOne(ctx, LocksColl, filter, update)
+	if err != nil {
+		return false, errors.New("Cannot extend lock " + name + ": " + err.Error())
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (mongoLocks) Release(ctx context.Context, name string, holder string) error {
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "holder", Value: holder}}
+	if _, err := mongoDB.DeleteMany(ctx, LocksColl, filter); err != nil {
+		return errors.New("Cannot release lock " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (mongoLocks) Held(ctx context.Context, names []string, at time.Time) (map[string]model.Lease, error) {
+	filter := bson.D{{Key: "_id", Value: bson.M{"$in": names}}, {Key: "expires_at", Value: bson.M{"$gte": at}}}
+	leases, err := mongoDB.Find[model.Lease](ctx, LocksColl, filter)
+	if err != nil {
+		return nil, errors.New("Cannot get locks: " + err.Error())
+	}
+	held := map[string]model.Lease{}
+	for _, lease := range leases {
+		held[lease.Name] = lease
+	}
+	return held, nil
+}
+
+func (mongoMigrations) Applied(ctx context.Context) ([]model.AppliedMigration, error) {
+	records, err := mongoDB.Find[model.AppliedMigration](ctx, migrationsColl, bson.D{}, mongoDB.SortOption{SortKey: "_id", Order: 1})
+	if err != nil {
+		return nil, errors.New("Cannot get applied migrations: " + err.Error())
+	}
+	return records, nil
+}
+
+func (mongoMigrations) Record(ctx context.Context, record model.AppliedMigration) error {
+	if _, err := mongoDB.InsertToCollection(ctx, migrationsColl, record); err != nil {
+		return errors.New(fmt.Sprintf("Cannot record migration %d %s: %s", record.Version, record.Name, err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,563 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the SQL migrations of the PostgreSQL storage, applied in the order of their version prefix
+//
+//go:embed sql/*.sql
+var sqlMigrations embed.FS
+
+const (
+	// key of the advisory lock instances starting together take turns on to migrate
+	migrateLockKey = 852
+
+	postColumns = "id, title, summary, locations, airlines, matches, dict_version, url, pub_date, created_at, source, body, image, author, fields, archived_at"
+	userColumns = "id, email, name, provider, avatar_url, last_updated, last_login, notification, telegram_uid, telegram_chat_id, role, selected_locations, selected_airlines"
+)
+
+type (
+	postgresPosts  struct{ pool *pgxpool.Pool }
+	postgresUsers  struct{ pool *pgxpool.Pool }
+	postgresSystem struct{ pool *pgxpool.Pool }
+
+	scanner interface {
+		Scan(dest ...any) error
+	}
+)
+
+var pool *pgxpool.Pool
+
+// UsePostgres connects to the PostgreSQL database of the URI, applies its pending SQL migrations and backs every
+// repository by it
+func UsePostgres(ctx context.Context, uri string) error {
+	p, err := pgxpool.New(ctx, uri)
+	if err != nil {
+		return errors.New("Cannot connect to PostgreSQL: " + err.Error())
+	}
+	if err = p.Ping(ctx); err != nil {
+		p.Close()
+		return errors.New("Cannot ping PostgreSQL: " + err.Error())
+	}
+	if err = migratePostgres(ctx, p); err != nil {
+		p.Close()
+		return err
+	}
+	fmt.Println("Connected to PostgreSQL")
+
+	pool = p
+	Posts, Users, System = postgresPosts{p}, postgresUsers{p}, postgresSystem{p}
+	Tags, Specs, Health = postgresTags{p}, postgresSpecs{p}, postgresHealth{p}
+	Jobs, Locks, Migrations = postgresJobs{p}, postgresLocks{p}, postgresMigrations{p}
+	storage = StoragePostgres
+	return nil
+}
+
+// migratePostgres applies the SQL migrations not recorded in schema_migrations, each in a transaction
+func migratePostgres(ctx context.Context, p *pgxpool.Pool) error {
+	conn, err := p.Acquire(ctx)
+	if err != nil {
+		return errors.New("Cannot migrate PostgreSQL: " + err.Error())
+	}
+	defer conn.Release()
+
+	// held by the session, across the transactions of the migrations
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrateLockKey); err != nil {
+		return errors.New("Cannot lock PostgreSQL migrations: " + err.Error())
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrateLockKey)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return errors.New("Cannot create schema_migrations: " + err.Error())
+	}
+	rows, _ := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return errors.New("Cannot get applied SQL migrations: " + err.Error())
+	}
+
+	// sorted by name, i.e. by version
+	files, err := fs.Glob(sqlMigrations, "sql/*.sql")
+	if err != nil {
+		return errors.New("Cannot list SQL migrations: " + err.Error())
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return errors.New("SQL migration " + file + " has no version prefix")
+		}
+		if slices.Contains(applied, version) {
+			continue
+		}
+
+		b, err := sqlMigrations.ReadFile(file)
+		if err != nil {
+			return errors.New("Cannot read SQL migration " + name + ": " + err.Error())
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, string(b)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", version, name)
+			return err
+		})
+		if err != nil {
+			return errors.New("Cannot apply SQL migration " + name + ": " + err.Error())
+		}
+		fmt.Println("Applied SQL migration", name)
+	}
+	return nil
+}
+
+// withTimeout bounds a statement by the configured timeout, as pkg/database/mongoDB does queries
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.Cfg.Database.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, config.Cfg.Database.Timeout)
+}
+
+// placeholders returns "$from, ..., $(from+n-1)"
+func placeholders(from int, n int) string {
+	output := []string{}
+	for i := from; i < from+n; i++ {
+		output = append(output, "$"+strconv.Itoa(i))
+	}
+	return strings.Join(output, ", ")
+}
+
+// text[] columns are not null
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func sourceNames(sources []model.DataSource) []string {
+	output := []string{}
+	for _, source := range sources {
+		output = append(output, string(source))
+	}
+	return output
+}
+
+func postWhere(f PostFilter) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !f.IncludeArchived {
+		conditions = append(conditions, "archived_at IS NULL")
+	}
+	if len(f.Locations) > 0 {
+		add("locations && $%d", f.Locations)
+	}
+	if len(f.Airlines) > 0 {
+		add("airlines && $%d", f.Airlines)
+	}
+	if len(f.Sources) > 0 {
+		add("source = ANY($%d)", sourceNames(f.Sources))
+	}
+	if !f.CreatedSince.IsZero() {
+		add("created_at >= $%d", f.CreatedSince)
+	}
+	if !f.PublishedFrom.IsZero() {
+		add("pub_date >= $%d", f.PublishedFrom)
+	}
+	if !f.PublishedTo.IsZero() {
+		add("pub_date < $%d", f.PublishedTo)
+	}
+	if f.TaggedBefore > 0 {
+		add("dict_version < $%d", f.TaggedBefore)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func scanPost(row scanner) (model.Post, error) {
+	var post model.Post
+	var id, source string
+	err := row.Scan(&id, &post.Title, &post.Summary, &post.Locations, &post.Airlines, &post.Matches, &post.DictVersion,
+		&post.URL, &post.PubDate, &post.CreatedAt, &source, &post.Body, &post.Image, &post.Author, &post.Fields, &post.ArchivedAt)
+	if err != nil {
+		return post, err
+	}
+	if post.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return post, err
+	}
+	post.Source = model.DataSource(source)
+	// as MongoDB returns them
+	post.PubDate, post.CreatedAt = post.PubDate.UTC(), post.CreatedAt.UTC()
+	if post.ArchivedAt != nil {
+		at := post.ArchivedAt.UTC()
+		post.ArchivedAt = &at
+	}
+	return post, nil
+}
+
+func postValues(post model.Post) []any {
+	id := post.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	return []any{id.Hex(), post.Title, post.Summary, orEmpty(post.Locations), orEmpty(post.Airlines), post.Matches,
+		post.DictVersion, post.URL, post.PubDate, post.CreatedAt, string(post.Source), post.Body, post.Image, post.Author,
+		post.Fields, post.ArchivedAt}
+}
+
+func (p postgresPosts) Find(ctx context.Context, f PostFilter) ([]model.Post, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	where, args := postWhere(f)
+	query := "SELECT " + postColumns + " FROM posts" + where + " ORDER BY pub_date DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, _ := p.pool.Query(ctx, query, args...)
+	posts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.Post, error) {
+		return scanPost(row)
+	})
+	if err != nil {
+		return nil, errors.New("Cannot find posts: " + err.Error())
+	}
+	return posts, nil
+}
+
+func (p postgresPosts) Page(ctx context.Context, f PostFilter, after primitive.ObjectID, n int64) ([]model.Post, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	where, args := postWhere(f)
+	if !after.IsZero() {
+		// hex IDs sort as the ObjectIDs do
+		args = append(args, after.Hex())
+		if where == "" {
+			where = fmt.Sprintf(" WHERE id > $%d", len(args))
+		} else {
+			where += fmt.Sprintf(" AND id > $%d", len(args))
+		}
+	}
+	args = append(args, n)
+	rows, _ := p.pool.Query(ctx, "SELECT "+postColumns+" FROM posts"+where+fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args)), args...)
+	posts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.Post, error) {
+		return scanPost(row)
+	})
+	if err != nil {
+		return nil, errors.New("Cannot find posts: " + err.Error())
+	}
+	return posts, nil
+}
+
+// Each streams the rows, so only the deadline of the caller bounds it
+func (p postgresPosts) Each(ctx context.Context, f PostFilter, fn func(model.Post) error) error {
+	// as MongoDB cannot stream the posts and the archive in order
+	if f.IncludeArchived {
+		return errors.WrapPrefix(ErrArchivedUnsupported, "Cannot stream posts", 0)
+	}
+	where, args := postWhere(f)
+	rows, err := p.pool.Query(ctx, "SELECT "+postColumns+" FROM posts"+where+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return errors.New("Cannot find posts: " + err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return errors.New("Cannot read post: " + err.Error())
+		}
+		if err = fn(post); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.New("Cannot find posts: " + err.Error())
+	}
+	return nil
+}
+
+func (p postgresPosts) StoredURLs(ctx context.Context, urls []string) (map[string]bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, _ := p.pool.Query(ctx, "SELECT url FROM posts WHERE url = ANY($1)", urls)
+	stored, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, errors.New("Cannot get stored posts: " + err.Error())
+	}
+	output := map[string]bool{}
+	for _, url := range stored {
+		output[url] = true
+	}
+	return output, nil
+}
+
+// Insert inserts all the posts or none
+func (p postgresPosts) Insert(ctx context.Context, posts []model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	batch := &pgx.Batch{}
+	for _, post := range posts {
+		batch.Queue("INSERT INTO posts ("+postColumns+") VALUES ("+placeholders(1, 16)+")", postValues(post)...)
+	}
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return errors.New("Cannot insert to posts table: " + err.Error())
+	}
+	return nil
+}
+
+func (p postgresPosts) SetTags(ctx context.Context, post model.Post) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, "UPDATE posts SET locations = $2, airlines = $3, matches = $4, dict_version = $5 WHERE id = $1",
+		post.ID.Hex(), orEmpty(post.Locations), orEmpty(post.Airlines), post.Matches, post.DictVersion)
+	if err != nil {
+		return errors.New("Cannot update post: " + err.Error())
+	}
+	return nil
+}
+
+// Archive stamps the posts in a single statement, so only the deadline of the caller bounds it
+func (p postgresPosts) Archive(ctx context.Context, f ArchiveFilter, at time.Time, dryRun bool) (map[model.DataSource]int64, error) {
+	conditions := "archived_at IS NULL AND pub_date < $1"
+	args := []any{f.PublishedBefore}
+	if len(f.Sources) > 0 {
+		conditions += " AND source = ANY($2)"
+		args = append(args, sourceNames(f.Sources))
+	} else if len(f.ExceptSources) > 0 {
+		conditions += " AND NOT (source = ANY($2))"
+		args = append(args, sourceNames(f.ExceptSources))
+	}
+
+	query := "SELECT source, count(*) FROM posts WHERE " + conditions + " GROUP BY source"
+	if !dryRun {
+		args = append(args, at)
+		query = fmt.Sprintf("WITH archived AS (UPDATE posts SET archived_at = $%d WHERE %s RETURNING source) "+
+			"SELECT source, count(*) FROM archived GROUP BY source", len(args), conditions)
+	}
+
+	archived := map[model.DataSource]int64{}
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return archived, errors.New("Cannot archive posts: " + err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source string
+		var n int64
+		if err = rows.Scan(&source, &n); err != nil {
+			return archived, errors.New("Cannot archive posts: " + err.Error())
+		}
+		archived[model.DataSource(source)] = n
+	}
+	if err = rows.Err(); err != nil {
+		return archived, errors.New("Cannot archive posts: " + err.Error())
+	}
+	return archived, nil
+}
+
+func scanUser(row scanner) (model.User, error) {
+	var user model.User
+	var notification, role int
+	err := row.Scan(&user.ID, &user.Email, &user.Name, &user.Provider, &user.AvatarURL, &user.LastUpdated, &user.LastLogin,
+		&notification, &user.TelegramUID, &user.TelegramChatID, &role, &user.SelectedLocations, &user.SelectedAirlines)
+	user.Notification, user.Role = model.Notification(notification), model.Role(role)
+	return user, err
+}
+
+func userValues(user model.User) []any {
+	return []any{user.ID, user.Email, user.Name, user.Provider, user.AvatarURL, user.LastUpdated, user.LastLogin,
+		int(user.Notification), user.TelegramUID, user.TelegramChatID, int(user.Role), orEmpty(user.SelectedLocations),
+		orEmpty(user.SelectedAirlines)}
+}
+
+func (p postgresUsers) one(ctx context.Context, column string, value string) (model.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	user, err := scanUser(p.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+column+" = $1", value))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, errors.WrapPrefix(ErrNotFound, "user of "+column+" "+value, 0)
+	}
+	if err != nil {
+		return user, errors.New("Cannot get user: " + err.Error())
+	}
+	return user, nil
+}
+
+func (p postgresUsers) Get(ctx context.Context, id string) (model.User, error) {
+	return p.one(ctx, "id", id)
+}
+
+func (p postgresUsers) ByTelegramUID(ctx context.Context, uid string) (model.User, error) {
+	return p.one(ctx, "telegram_uid", uid)
+}
+
+func (p postgresUsers) Insert(ctx context.Context, user model.User) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if _, err := p.pool.Exec(ctx, "INSERT INTO users ("+userColumns+") VALUES ("+placeholders(1, 13)+")", userValues(user)...); err != nil {
+		return errors.New("Cannot insert user: " + err.Error())
+	}
+	return nil
+}
+
+func (p postgresUsers) Upsert(ctx context.Context, user model.User) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	set := []string{}
+	for _, column := range strings.Split(userColumns, ", ")[1:] {
+		set = append(set, column+" = EXCLUDED."+column)
+	}
+	query := "INSERT INTO users (" + userColumns + ") VALUES (" + placeholders(1, 13) + ") ON CONFLICT (id) DO UPDATE SET " +
+		strings.Join(set, ", ")
+	if _, err := p.pool.Exec(ctx, query, userValues(user)...); err != nil {
+		return errors.New("Cannot upsert user: " + err.Error())
+	}
+	return nil
+}
+
+func (p postgresUsers) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if _, err := p.pool.Exec(ctx, "UPDATE users SET last_login = $2 WHERE id = $1", id, at); err != nil {
+		return errors.New("Cannot update user: " + err.Error())
+	}
+	return nil
+}
+
+func (p postgresUsers) SetSubscription(ctx context.Context, id string, query model.Query, notification model.Notification) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		"UPDATE users SET last_updated = $2, selected_locations = $3, selected_airlines = $4, notification = $5 WHERE id = $1",
+		id, time.Now().UTC(), orEmpty(query.SelectedLocations), orEmpty(query.SelectedAirlines), int(notification))
+	if err != nil {
+		return errors.New("Cannot update user: " + err.Error())
+	}
+	return nil
+}
+
+// EachSubscriber streams the rows, so only the deadline of the caller bounds it
+func (p postgresUsers) EachSubscriber(ctx context.Context, ids []string, fn func(model.User) error) error {
+	query, arg := "SELECT "+userColumns+" FROM users WHERE notification = $1 ORDER BY id", any(int(model.NotificationOn))
+	if len(ids) > 0 {
+		query, arg = "SELECT "+userColumns+" FROM users WHERE id = ANY($1) ORDER BY id", ids
+	}
+	rows, err := p.pool.Query(ctx, query, arg)
+	if err != nil {
+		return errors.New("Cannot find users: " + err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return errors.New("Cannot read user: " + err.Error())
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.New("Cannot find users: " + err.Error())
+	}
+	return nil
+}
+
+func (s postgresSystem) Watermarks(ctx context.Context) (model.Watermarks, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	output := model.Watermarks{Sources: map[string]time.Time{}}
+	var lastUpdated *time.Time
+	err := s.pool.QueryRow(ctx, "SELECT last_updated FROM system_state WHERE id = 'scrapper'").Scan(&lastUpdated)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return output, errors.New("Cannot get watermarks: " + err.Error())
+	}
+	if lastUpdated != nil {
+		output.LastUpdated = lastUpdated.UTC()
+	}
+
+	rows, _ := s.pool.Query(ctx, "SELECT source, at FROM source_watermarks")
+	var source string
+	var at time.Time
+	_, err = pgx.ForEachRow(rows, []any{&source, &at}, func() error {
+		output.Sources[source] = at.UTC()
+		return nil
+	})
+	if err != nil {
+		return output, errors.New("Cannot get watermarks: " + err.Error())
+	}
+	return output, nil
+}
+
+func (s postgresSystem) SetWatermarks(ctx context.Context, sources []model.DataSource, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO system_state (id, last_updated) VALUES ('scrapper', $1) "+
+		"ON CONFLICT (id) DO UPDATE SET last_updated = EXCLUDED.last_updated", at)
+	for _, source := range sources {
+		batch.Queue("INSERT INTO source_watermarks (source, at) VALUES ($1, $2) ON CONFLICT (source) DO UPDATE SET at = EXCLUDED.at",
+			string(source), at)
+	}
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return errors.New("Cannot update watermarks: " + err.Error())
+	}
+	return nil
+}
+
+func (s postgresSystem) DictVersion(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var version int64
+	err := s.pool.QueryRow(ctx, "SELECT version FROM system_state WHERE id = 'tags'").Scan(&version)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, errors.New("Cannot get tag dictionary version: " + err.Error())
+	}
+	return version, nil
+}
+
+func (s postgresSystem) BumpDictVersion(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := s.pool.Exec(ctx, "INSERT INTO system_state (id, version, last_updated) VALUES ('tags', 1, $1) "+
+		"ON CONFLICT (id) DO UPDATE SET version = system_state.version + 1, last_updated = EXCLUDED.last_updated", time.Now().UTC())
+	if err != nil {
+		return errors.New("Cannot update tag dictionary version: " + err.Error())
+	}
+	return nil
+}
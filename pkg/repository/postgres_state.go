@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const jobColumns = "name, schedule, next_run, last_run, last_duration_ms, last_error, last_result, triggered_at, claimed_slot"
+
+type (
+	postgresTags       struct{ pool *pgxpool.Pool }
+	postgresSpecs      struct{ pool *pgxpool.Pool }
+	postgresHealth     struct{ pool *pgxpool.Pool }
+	postgresJobs       struct{ pool *pgxpool.Pool }
+	postgresLocks      struct{ pool *pgxpool.Pool }
+	postgresMigrations struct{ pool *pgxpool.Pool }
+)
+
+// documents returns the jsonb documents of the query, decoded into their model
+func documents[T any](ctx context.Context, p *pgxpool.Pool, query string, args ...any) ([]T, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, _ := p.Query(ctx, query, args...)
+	return pgx.CollectRows(rows, pgx.RowTo[T])
+}
+
+// exec runs a statement bounded by the configured timeout
+func exec(ctx context.Context, p *pgxpool.Pool, query string, args ...any) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := p.Exec(ctx, query, args...)
+	return err
+}
+
+func (t postgresTags) List(ctx context.Context) ([]model.TagEntry, error) {
+	entries, err := documents[model.TagEntry](ctx, t.pool, "SELECT entry FROM tag_entries ORDER BY id")
+	if err != nil {
+		return nil, errors.New("Cannot get tag entries: " + err.Error())
+	}
+	return entries, nil
+}
+
+func (t postgresTags) Insert(ctx context.Context, entry model.TagEntry) error {
+	if err := exec(ctx, t.pool, "INSERT INTO tag_entries (id, entry) VALUES ($1, $2)", entry.ID, entry); err != nil {
+		return errors.New("Cannot insert tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (t postgresTags) Save(ctx context.Context, entry model.TagEntry) error {
+	err := exec(ctx, t.pool, "INSERT INTO tag_entries (id, entry) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET entry = EXCLUDED.entry",
+		entry.ID, entry)
+	if err != nil {
+		return errors.New("Cannot save tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (t postgresTags) Delete(ctx context.Context, id string) error {
+	if err := exec(ctx, t.pool, "DELETE FROM tag_entries WHERE id = $1", id); err != nil {
+		return errors.New("Cannot delete tag entry: " + err.Error())
+	}
+	return nil
+}
+
+func (s postgresSpecs) List(ctx context.Context) ([]model.SourceSpec, error) {
+	specs, err := documents[model.SourceSpec](ctx, s.pool, "SELECT spec FROM source_specs ORDER BY name")
+	if err != nil {
+		return nil, errors.New("Cannot get source specs: " + err.Error())
+	}
+	return specs, nil
+}
+
+func (s postgresSpecs) Save(ctx context.Context, spec model.SourceSpec) error {
+	err := exec(ctx, s.pool, "INSERT INTO source_specs (name, spec) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET spec = EXCLUDED.spec",
+		string(spec.Name), spec)
+	if err != nil {
+		return errors.New("Cannot save source spec: " + err.Error())
+	}
+	return nil
+}
+
+func (h postgresHealth) Runs(ctx context.Context, source model.DataSource, n int64) ([]model.SourceRun, error) {
+	runs, err := documents[model.SourceRun](ctx, h.pool, "SELECT run FROM source_runs WHERE source = $1 ORDER BY at DESC LIMIT $2",
+		string(source), n)
+	if err != nil {
+		return nil, errors.New("Cannot get source runs: " + err.Error())
+	}
+	return runs, nil
+}
+
+func (h postgresHealth) InsertRun(ctx context.Context, run model.SourceRun) error {
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	err := exec(ctx, h.pool, "INSERT INTO source_runs (id, source, at, run) VALUES ($1, $2, $3, $4)",
+		run.ID.Hex(), string(run.Source), run.At, run)
+	if err != nil {
+		return errors.New("Cannot insert source run: " + err.Error())
+	}
+	return nil
+}
+
+func (h postgresHealth) Get(ctx context.Context, source model.DataSource) (model.SourceHealth, error) {
+	healths, err := documents[model.SourceHealth](ctx, h.pool, "SELECT health FROM source_health WHERE source = $1", string(source))
+	if err != nil {
+		return model.SourceHealth{}, errors.New("Cannot get source health: " + err.Error())
+	}
+	if len(healths) == 0 {
+		return model.SourceHealth{}, errors.WrapPrefix(ErrNotFound, "health of "+string(source), 0)
+	}
+	return healths[0], nil
+}
+
+func (h postgresHealth) Save(ctx context.Context, health model.SourceHealth) error {
+	err := exec(ctx, h.pool, "INSERT INTO source_health (source, health) VALUES ($1, $2) ON CONFLICT (source) DO UPDATE SET health = EXCLUDED.health",
+		string(health.Source), health)
+	if err != nil {
+		return errors.New("Cannot save source health: " + err.Error())
+	}
+	return nil
+}
+
+func (h postgresHealth) List(ctx context.Context) ([]model.SourceHealth, error) {
+	healths, err := documents[model.SourceHealth](ctx, h.pool, "SELECT health FROM source_health ORDER BY source")
+	if err != nil {
+		return nil, errors.New("Cannot get source health: " + err.Error())
+	}
+	return healths, nil
+}
+
+func scanJob(row pgx.CollectableRow) (model.JobState, error) {
+	var job model.JobState
+	err := row.Scan(&job.Name, &job.Schedule, &job.NextRun, &job.LastRun, &job.LastDuration, &job.LastError, &job.LastResult, &job.TriggeredAt,
+		&job.ClaimedSlot)
+	// as MongoDB returns them
+	for _, at := range []**time.Time{&job.NextRun, &job.LastRun, &job.TriggeredAt, &job.ClaimedSlot} {
+		if *at != nil {
+			utc := (*at).UTC()
+			*at = &utc
+		}
+	}
+	return job, err
+}
+
+func (j postgresJobs) find(ctx context.Context, where string, args ...any) ([]model.JobState, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, _ := j.pool.Query(ctx, "SELECT "+jobColumns+" FROM jobs"+where+" ORDER BY name", args...)
+	return pgx.CollectRows(rows, scanJob)
+}
+
+func (j postgresJobs) List(ctx context.Context) ([]model.JobState, error) {
+	jobs, err := j.find(ctx, "")
+	if err != nil {
+		return nil, errors.New("Cannot get jobs: " + err.Error())
+	}
+	return jobs, nil
+}
+
+func (j postgresJobs) Get(ctx context.Context, name string) (model.JobState, error) {
+	jobs, err := j.find(ctx, " WHERE name = $1", name)
+	if err != nil {
+		return model.JobState{}, errors.New("Cannot get job: " + err.Error())
+	}
+	if len(jobs) == 0 {
+		return model.JobState{}, errors.WrapPrefix(ErrNotFound, "job "+name, 0)
+	}
+	return jobs[0], nil
+}
+
+func (j postgresJobs) SetSchedule(ctx context.Context, name string, schedule string, next time.Time) error {
+	err := exec(ctx, j.pool, "INSERT INTO jobs (name, schedule, next_run) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (name) DO UPDATE SET schedule = EXCLUDED.schedule, next_run = EXCLUDED.next_run", name, schedule, next)
+	if err != nil {
+		return errors.New("Cannot save schedule of job " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (j postgresJobs) SetLastRun(ctx context.Context, name string, at time.Time, duration time.Duration, lastError string, result string) error {
+	err := exec(ctx, j.pool, "INSERT INTO jobs (name, last_run, last_duration_ms, last_error, last_result) VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (name) DO UPDATE SET last_run = EXCLUDED.last_run, last_duration_ms = EXCLUDED.last_duration_ms, "+
+		"last_error = EXCLUDED.last_error, last_result = EXCLUDED.last_result", name, at, duration.Milliseconds(), lastError, result)
+	if err != nil {
+		return errors.New("Cannot save last run of job " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (j postgresJobs) ClaimSlot(ctx context.Context, name string, slot time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := j.pool.Exec(ctx, "UPDATE jobs SET claimed_slot = $2 WHERE name = $1 AND (claimed_slot IS NULL OR claimed_slot < $2)",
+		name, slot)
+	if err != nil {
+		return false, errors.New("Cannot claim slot of job " + name + ": " + err.Error())
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (j postgresJobs) Trigger(ctx context.Context, name string, at time.Time) error {
+	if err := exec(ctx, j.pool, "UPDATE jobs SET triggered_at = $2 WHERE name = $1", name, at); err != nil {
+		return errors.New("Cannot trigger job: " + err.Error())
+	}
+	return nil
+}
+
+func (j postgresJobs) Triggered(ctx context.Context) ([]model.JobState, error) {
+	jobs, err := j.find(ctx, " WHERE triggered_at IS NOT NULL")
+	if err != nil {
+		return nil, errors.New("Cannot get triggered jobs: " + err.Error())
+	}
+	return jobs, nil
+}
+
+func (j postgresJobs) ClearTrigger(ctx context.Context, name string, at time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := j.pool.Exec(ctx, "UPDATE jobs SET triggered_at = NULL WHERE name = $1 AND triggered_at = $2", name, at)
+	if err != nil {
+		return false, errors.New("Cannot clear trigger of job " + name + ": " + err.Error())
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func scanLease(row pgx.CollectableRow) (model.Lease, error) {
+	var lease model.Lease
+	err := row.Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	lease.AcquiredAt, lease.ExpiresAt = lease.AcquiredAt.UTC(), lease.ExpiresAt.UTC()
+	return lease, err
+}
+
+func (l postgresLocks) Acquire(ctx context.Context, lease model.Lease) (model.Lease, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	// the insert of a lease held by another leaves it as it is, and returns no row
+	rows, _ := l.pool.Query(ctx, "INSERT INTO locks (name, holder, acquired_at, expires_at) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, acquired_at = EXCLUDED.acquired_at, expires_at = EXCLUDED.expires_at "+
+		"WHERE locks.expires_at < EXCLUDED.acquired_at RETURNING name, holder, acquired_at, expires_at",
+		lease.Name, lease.Holder, lease.AcquiredAt, lease.ExpiresAt)
+	acquired, err := pgx.CollectRows(rows, scanLease)
+	if err != nil {
+		return lease, errors.New("Cannot acquire lock " + lease.Name + ": " + err.Error())
+	}
+	if len(acquired) > 0 {
+		return acquired[0], nil
+	}
+
+	rows, _ = l.pool.Query(ctx, "SELECT name, holder, acquired_at, expires_at FROM locks WHERE name = $1", lease.Name)
+	current, err := pgx.CollectRows(rows, scanLease)
+	if err != nil {
+		return lease, errors.New("Cannot get lock " + lease.Name + ": " + err.Error())
+	}
+	// released since, by a holder other than the caller all the same
+	if len(current) == 0 {
+		return model.Lease{Name: lease.Name}, nil
+	}
+	return current[0], nil
+}
+
+func (l postgresLocks) Extend(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := l.pool.Exec(ctx, "UPDATE locks SET expires_at = $3 WHERE name = $1 AND holder = $2", name, holder, until)
+	if err != nil {
+		return false, errors.New("Cannot extend lock " + name + ": " + err.Error())
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (l postgresLocks) Release(ctx context.Context, name string, holder string) error {
+	if err := exec(ctx, l.pool, "DELETE FROM locks WHERE name = $1 AND holder = $2", name, holder); err != nil {
+		return errors.New("Cannot release lock " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+func (l postgresLocks) Held(ctx context.Context, names []string, at time.Time) (map[string]model.Lease, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, _ := l.pool.Query(ctx, "SELECT name, holder, acquired_at, expires_at FROM locks WHERE name = ANY($1) AND expires_at >= $2",
+		orEmpty(names), at)
+	leases, err := pgx.CollectRows(rows, scanLease)
+	if err != nil {
+		return nil, errors.New("Cannot get locks: " + err.Error())
+	}
+	held := map[string]model.Lease{}
+	for _, lease := range leases {
+		held[lease.Name] = lease
+	}
+	return held, nil
+}
+
+func (m postgresMigrations) Applied(ctx context.Context) ([]model.AppliedMigration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, _ := m.pool.Query(ctx, "SELECT version, name, applied_at, duration_ms FROM data_migrations ORDER BY version")
+	records, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.AppliedMigration, error) {
+		var record model.AppliedMigration
+		err := row.Scan(&record.Version, &record.Name, &record.AppliedAt, &record.Duration)
+		record.AppliedAt = record.AppliedAt.UTC()
+		return record, err
+	})
+	if err != nil {
+		return nil, errors.New("Cannot get applied migrations: " + err.Error())
+	}
+	return records, nil
+}
+
+func (m postgresMigrations) Record(ctx context.Context, record model.AppliedMigration) error {
+	err := exec(ctx, m.pool, "INSERT INTO data_migrations (version, name, applied_at, duration_ms) VALUES ($1, $2, $3, $4)",
+		record.Version, record.Name, record.AppliedAt, record.Duration)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot record migration %d %s: %s", record.Version, record.Name, err.Error()))
+	}
+	return nil
+}
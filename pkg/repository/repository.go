@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/config"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// see PostRepository.Each
+	ErrArchivedUnsupported = errors.New("archived posts are not streamed")
+)
+
+type (
+	// PostFilter narrows posts. Empty fields match any post
+	PostFilter struct {
+		Locations    []string // any of, as stored, i.e. with descendants already expanded
+		Airlines     []string // any of, with airline groups already expanded
+		Sources      []model.DataSource
+		CreatedSince time.Time
+		// tagged with a dictionary version below it, or never tagged, e.g. to re-tag posts
+		TaggedBefore int64
+		// published from PublishedFrom and before PublishedTo, either unbounded when zero
+		PublishedFrom time.Time
+		PublishedTo   time.Time
+		// also match the posts moved to the archive past their retention, which Each does not stream
+		IncludeArchived bool
+		// of the posts Find returns, all when zero, e.g. to bound queries including the archive
+		Limit int64
+	}
+
+	// ArchiveFilter selects the live posts published before PublishedBefore, of the sources or, if none,
+	// of every source but the excluded ones
+	ArchiveFilter struct {
+		Sources         []model.DataSource
+		ExceptSources   []model.DataSource
+		PublishedBefore time.Time
+	}
+
+	PostRepository interface {
+		// Find returns the posts matching the filter, latest published first
+		Find(ctx context.Context, filter PostFilter) ([]model.Post, error)
+		// Each streams the live posts matching the filter through fn, latest created first, stopping at its first
+		// error. It returns ErrArchivedUnsupported for filters including the archive, which Page goes through
+		Each(ctx context.Context, filter PostFilter, fn func(model.Post) error) error
+		// Page returns up to n posts matching the filter with IDs after the given one, or from the first if it
+		// is zero, by ID, e.g. to go through many posts without holding a cursor open
+		Page(ctx context.Context, filter PostFilter, after primitive.ObjectID, n int64) ([]model.Post, error)
+		// StoredURLs tells which of the URLs are stored, live or archived
+		StoredURLs(ctx context.Context, urls []string) (map[string]bool, error)
+		Insert(ctx context.Context, posts []model.Post) error
+		// SetTags stores the locations, airlines, matches and dictionary version of the post
+		SetTags(ctx context.Context, post model.Post) error
+		// Archive moves the posts matching the filter to the archive, stamped with at, and returns the number
+		// moved, or to move on a dry run, by source
+		Archive(ctx context.Context, filter ArchiveFilter, at time.Time, dryRun bool) (map[model.DataSource]int64, error)
+	}
+
+	UserRepository interface {
+		// Get returns ErrNotFound for unknown IDs
+		Get(ctx context.Context, id string) (model.User, error)
+		// ByTelegramUID returns ErrNotFound when no user has the UID
+		ByTelegramUID(ctx context.Context, uid string) (model.User, error)
+		Insert(ctx context.Context, user model.User) error
+		// Upsert stores the user as it is, replacing the one with its ID
+		Upsert(ctx context.Context, user model.User) error
+		SetLastLogin(ctx context.Context, id string, at time.Time) error
+		// SetSubscription updates the filters and the notification setting of the user
+		SetSubscription(ctx context.Context, id string, query model.Query, notification model.Notification) error
+		// EachSubscriber streams the users with notifications on through fn, or the users of the IDs whatever
+		// their setting
+		EachSubscriber(ctx context.Context, ids []string, fn func(model.User) error) error
+	}
+
+	// SystemRepository keeps the state shared by instances
+	SystemRepository interface {
+		// Watermarks are zero before the first scrape
+		Watermarks(ctx context.Context) (model.Watermarks, error)
+		// SetWatermarks moves the sources, and the time of the last run, to at
+		SetWatermarks(ctx context.Context, sources []model.DataSource, at time.Time) error
+		// DictVersion is 0 until the dictionaries are first changed
+		DictVersion(ctx context.Context) (int64, error)
+		BumpDictVersion(ctx context.Context) error
+	}
+
+	// TagRepository keeps the entries of the tag dictionaries edited through pkg/dictionary
+	TagRepository interface {
+		// List returns the entries by ID
+		List(ctx context.Context) ([]model.TagEntry, error)
+		Insert(ctx context.Context, entry model.TagEntry) error
+		// Save stores the entry as it is, replacing the one with its ID
+		Save(ctx context.Context, entry model.TagEntry) error
+		Delete(ctx context.Context, id string) error
+	}
+
+	// SpecRepository keeps the source specs of pkg/scrapper saved over the default ones
+	SpecRepository interface {
+		List(ctx context.Context) ([]model.SourceSpec, error)
+		// Save stores the spec as it is, replacing the one with its name
+		Save(ctx context.Context, spec model.SourceSpec) error
+	}
+
+	// HealthRepository keeps the runs of the sources and the health pkg/health makes of them
+	HealthRepository interface {
+		// Runs returns the last n runs of the source, latest first
+		Runs(ctx context.Context, source model.DataSource, n int64) ([]model.SourceRun, error)
+		InsertRun(ctx context.Context, run model.SourceRun) error
+		// Get returns ErrNotFound for sources never recorded
+		Get(ctx context.Context, source model.DataSource) (model.SourceHealth, error)
+		// Save stores the health as it is, replacing the one of its source
+		Save(ctx context.Context, health model.SourceHealth) error
+		// List returns the health of every source recorded, by source
+		List(ctx context.Context) ([]model.SourceHealth, error)
+	}
+
+	// JobRepository keeps the schedules, runs and triggers of the jobs of pkg/scheduler. Whether a job is
+	// running is not stored, see pkg/lock
+	JobRepository interface {
+		// List returns the jobs by name
+		List(ctx context.Context) ([]model.JobState, error)
+		// Get returns ErrNotFound for jobs never scheduled
+		Get(ctx context.Context, name string) (model.JobState, error)
+		// SetSchedule stores the schedule and the next run of the job, adding the job if missing
+		SetSchedule(ctx context.Context, name string, schedule string, next time.Time) error
+		// SetLastRun stores the last run of the job, adding the job if missing
+		SetLastRun(ctx context.Context, name string, at time.Time, duration time.Duration, lastError string, result string) error
+		// ClaimSlot tells whether the caller claimed the slot of the job, i.e. a time it is scheduled at, before
+		// any other caller did, so that instances sharing the schedule run the job once per slot
+		ClaimSlot(ctx context.Context, name string, slot time.Time) (bool, error)
+		// Trigger asks for a run of the job
+		Trigger(ctx context.Context, name string, at time.Time) error
+		// Triggered returns the jobs with a trigger pending
+		Triggered(ctx context.Context) ([]model.JobState, error)
+		// ClearTrigger clears the trigger of the job made at the time, and tells whether the caller cleared it,
+		// so that each trigger is picked up once
+		ClearTrigger(ctx context.Context, name string, at time.Time) (bool, error)
+	}
+
+	// LockRepository keeps the leases of pkg/lock
+	LockRepository interface {
+		// Acquire stores the lease unless another one of its name is held at its AcquiredAt, i.e. has not
+		// expired by then, and returns the lease held once done, the new one or the other one
+		Acquire(ctx context.Context, lease model.Lease) (model.Lease, error)
+		// Extend moves the expiry of the lease of the name and holder, and tells whether they still hold it
+		Extend(ctx context.Context, name string, holder string, until time.Time) (bool, error)
+		// Release deletes the lease of the name and holder, if they still hold it
+		Release(ctx context.Context, name string, holder string) error
+		// Held returns the leases of the names held at the time, by name
+		Held(ctx context.Context, names []string, at time.Time) (map[string]model.Lease, error)
+	}
+
+	// MigrationRepository keeps the records of the migrations of pkg/migration applied
+	MigrationRepository interface {
+		Applied(ctx context.Context) ([]model.AppliedMigration, error)
+		Record(ctx context.Context, record model.AppliedMigration) error
+	}
+)
+
+// the repositories used, backed by MongoDB unless Use, UsePostgres or UseMemory is called
+var (
+	Posts      PostRepository      = mongoPosts{}
+	Users      UserRepository      = mongoUsers{}
+	System     SystemRepository    = mongoSystem{}
+	Tags       TagRepository       = mongoTags{}
+	Specs      SpecRepository      = mongoSpecs{}
+	Health     HealthRepository    = mongoHealth{}
+	Jobs       JobRepository       = mongoJobs{}
+	Locks      LockRepository      = mongoLocks{}
+	Migrations MigrationRepository = mongoMigrations{}
+)
+
+// the storage backing the repositories
+const (
+	StorageMongo    = "mongodb"
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+var (
+	storage = StorageMongo
+	// whether Use connected to MongoDB, to be disconnected by Close
+	connected bool
+)
+
+// Storage tells which of StorageMongo, StoragePostgres and StorageMemory backs the repositories, e.g. to skip
+// the migrations of data only MongoDB has
+func Storage() string {
+	return storage
+}
+
+// UseMemory backs the repositories by memory, starting empty, so that handlers, jobs, locks and migrations can
+// be run without a database
+func UseMemory() {
+	Posts, Users, System = NewMemoryPosts(), NewMemoryUsers(), NewMemorySystem()
+	Tags, Specs, Health = NewMemoryTags(), NewMemorySpecs(), NewMemoryHealth()
+	Jobs, Locks, Migrations = NewMemoryJobs(), NewMemoryLocks(), NewMemoryMigrations()
+	storage = StorageMemory
+}
+
+// Use connects to the database of the URI, chosen by its scheme, and backs every repository by it:
+// MongoDB, at FLIGHTAGG_MONGODB_URI, for an empty URI or mongodb://, and PostgreSQL for postgres://. Either
+// needs no other database
+func Use(ctx context.Context, uri string) error {
+	if uri == "" {
+		return useMongo(ctx)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return errors.New("Invalid storage URI: " + err.Error())
+	}
+	switch u.Scheme {
+	case "mongodb", "mongodb+srv":
+		if uri != config.Cfg.Database.MongodbUri {
+			return errors.New("Storage URI must be the MongoDB URI, or a PostgreSQL one")
+		}
+		return useMongo(ctx)
+	case "postgres", "postgresql":
+		return UsePostgres(ctx, uri)
+	default:
+		return errors.New("Unsupported storage URI scheme " + u.Scheme)
+	}
+}
+
+func useMongo(ctx context.Context) error {
+	if err := mongoDB.InitDB(ctx); err != nil {
+		return err
+	}
+	Posts, Users, System = mongoPosts{}, mongoUsers{}, mongoSystem{}
+	Tags, Specs, Health = mongoTags{}, mongoSpecs{}, mongoHealth{}
+	Jobs, Locks, Migrations = mongoJobs{}, mongoLocks{}, mongoMigrations{}
+	storage, connected = StorageMongo, true
+	return nil
+}
+
+// Close disconnects from the database used
+func Close() error {
+	if pool != nil {
+		pool.Close()
+		pool = nil
+	}
+	if connected {
+		connected = false
+		return mongoDB.Disconnect(context.Background())
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+-- posts, live and archived. Archived posts have archived_at set, and are kept for price trends and
+-- seasonal analysis
+CREATE TABLE posts (
+    id           text PRIMARY KEY,
+    title        text NOT NULL,
+    summary      text NOT NULL DEFAULT '',
+    locations    text[] NOT NULL DEFAULT '{}',
+    airlines     text[] NOT NULL DEFAULT '{}',
+    matches      jsonb,
+    dict_version bigint NOT NULL DEFAULT 0,
+    url          text NOT NULL UNIQUE,
+    pub_date     timestamptz NOT NULL,
+    created_at   timestamptz NOT NULL,
+    source       text NOT NULL,
+    body         text NOT NULL DEFAULT '',
+    image        text NOT NULL DEFAULT '',
+    author       text NOT NULL DEFAULT '',
+    fields       jsonb,
+    archived_at  timestamptz
+);
@@ -0,0 +1,20 @@
+CREATE TABLE users (
+    id                 text PRIMARY KEY,
+    email              text NOT NULL DEFAULT '',
+    name               text NOT NULL DEFAULT '',
+    provider           text NOT NULL DEFAULT '',
+    avatar_url         text NOT NULL DEFAULT '',
+    last_updated       timestamptz,
+    last_login         timestamptz,
+    notification       integer NOT NULL DEFAULT 0,
+    telegram_uid       text NOT NULL DEFAULT '',
+    telegram_chat_id   bigint NOT NULL DEFAULT 0,
+    role               integer NOT NULL DEFAULT 0,
+    selected_locations text[] NOT NULL DEFAULT '{}',
+    selected_airlines  text[] NOT NULL DEFAULT '{}'
+);
+
+-- links the Telegram chat of a user on /start
+CREATE UNIQUE INDEX users_telegram_uid ON users (telegram_uid) WHERE telegram_uid <> '';
+-- subscribers streamed by alerts
+CREATE INDEX users_notification ON users (notification);
@@ -0,0 +1,13 @@
+-- state shared by instances: "scrapper" has the time of the last scrape, "tags" the version of the tag
+-- dictionaries
+CREATE TABLE system_state (
+    id           text PRIMARY KEY,
+    last_updated timestamptz,
+    version      bigint NOT NULL DEFAULT 0
+);
+
+-- the publication date each source has been scrapped up to
+CREATE TABLE source_watermarks (
+    source text PRIMARY KEY,
+    at     timestamptz NOT NULL
+);
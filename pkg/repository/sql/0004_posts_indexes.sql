@@ -0,0 +1,8 @@
+-- the posts API and alerts filter on any of the locations and airlines, i.e. the && operator
+CREATE INDEX posts_locations ON posts USING GIN (locations);
+CREATE INDEX posts_airlines ON posts USING GIN (airlines);
+CREATE INDEX posts_pub_date ON posts (pub_date DESC) WHERE archived_at IS NULL;
+CREATE INDEX posts_created_at ON posts (created_at DESC);
+-- retention is per source
+CREATE INDEX posts_source_pub_date ON posts (source, pub_date) WHERE archived_at IS NULL;
+CREATE INDEX posts_dict_version ON posts (dict_version);
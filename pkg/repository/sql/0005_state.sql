@@ -0,0 +1,55 @@
+-- the state pkg/repository keeps besides posts, users and system_state. Documents edited as a whole are kept
+-- as jsonb, in the JSON of their model
+
+-- entries of the tag dictionaries
+CREATE TABLE tag_entries (
+    id    text PRIMARY KEY,
+    entry jsonb NOT NULL
+);
+
+-- source specs saved over the default ones
+CREATE TABLE source_specs (
+    name text PRIMARY KEY,
+    spec jsonb NOT NULL
+);
+
+CREATE TABLE source_runs (
+    id     text PRIMARY KEY,
+    source text NOT NULL,
+    at     timestamptz NOT NULL,
+    run    jsonb NOT NULL
+);
+-- the trailing runs of a source are checked against on each run
+CREATE INDEX source_runs_source_at ON source_runs (source, at DESC);
+
+CREATE TABLE source_health (
+    source text PRIMARY KEY,
+    health jsonb NOT NULL
+);
+
+-- schedules, runs and triggers of the jobs of pkg/scheduler
+CREATE TABLE jobs (
+    name             text PRIMARY KEY,
+    schedule         text NOT NULL DEFAULT '',
+    next_run         timestamptz,
+    last_run         timestamptz,
+    last_duration_ms bigint NOT NULL DEFAULT 0,
+    last_error       text NOT NULL DEFAULT '',
+    triggered_at     timestamptz
+);
+
+-- leases of pkg/lock
+CREATE TABLE locks (
+    name        text PRIMARY KEY,
+    holder      text NOT NULL,
+    acquired_at timestamptz NOT NULL,
+    expires_at  timestamptz NOT NULL
+);
+
+-- steps of pkg/migration applied, unlike schema_migrations which records these files
+CREATE TABLE data_migrations (
+    version     integer PRIMARY KEY,
+    name        text NOT NULL,
+    applied_at  timestamptz NOT NULL,
+    duration_ms bigint NOT NULL
+);
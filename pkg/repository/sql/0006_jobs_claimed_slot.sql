@@ -0,0 +1,2 @@
+-- the last time each job was scheduled at that an instance claimed to run it
+ALTER TABLE jobs ADD COLUMN claimed_slot timestamptz;
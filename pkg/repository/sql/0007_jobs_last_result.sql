@@ -0,0 +1,2 @@
+-- what the last run of each job reported
+ALTER TABLE jobs ADD COLUMN last_result text NOT NULL DEFAULT '';
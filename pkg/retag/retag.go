@@ -0,0 +1,169 @@
+package retag
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultBatchSize = 200
+
+type (
+	Options struct {
+		DryRun    bool  `json:"dry_run"`
+		BatchSize int64 `json:"batch_size"`
+		// re-tag every post instead of only those tagged with an older version of the dictionaries
+		All bool `json:"all"`
+		// diffs kept in the report, all when 0. Changed counts them all the same
+		MaxDiffs int `json:"max_diffs"`
+	}
+
+	Diff struct {
+		PostID           string   `json:"post_id"`
+		Title            string   `json:"title"`
+		AddedLocations   []string `json:"added_locations,omitempty"`
+		RemovedLocations []string `json:"removed_locations,omitempty"`
+		AddedAirlines    []string `json:"added_airlines,omitempty"`
+		RemovedAirlines  []string `json:"removed_airlines,omitempty"`
+	}
+
+	Report struct {
+		DryRun  bool   `json:"dry_run"`
+		Version int64  `json:"version"`
+		Scanned int    `json:"scanned"`
+		Changed int    `json:"changed"`
+		Diffs   []Diff `json:"diffs"`
+	}
+)
+
+// fields stored on posts that extraction can be re-run over. Matches from other fields, e.g. categories,
+// are kept as they are
+var refreshableFields = []string{model.FieldTitle, model.FieldSummary, model.FieldBody}
+
+// Run re-runs tag extraction over the stored title, summary and body of posts, in batches. Posts are stamped
+// with the current dictionary version, so that later runs skip them unless All is set
+func Run(ctx context.Context, opts Options) (Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	version := tags.Version()
+	report := Report{DryRun: opts.DryRun, Version: version, Diffs: []Diff{}}
+
+	filter := repository.PostFilter{}
+	if !opts.All {
+		filter.TaggedBefore = version
+	}
+
+	var lastID primitive.ObjectID
+	for {
+		posts, err := repository.Posts.Page(ctx, filter, lastID, opts.BatchSize)
+		if err != nil {
+			return report, errors.New("Cannot get posts: " + err.Error())
+		}
+		if len(posts) == 0 {
+			break
+		}
+		lastID = posts[len(posts)-1].ID
+
+		for _, post := range posts {
+			report.Scanned++
+			retagged := Retag(post, version)
+			diff, changed := diffOf(post, retagged)
+			if changed {
+				report.Changed++
+				if opts.MaxDiffs <= 0 || len(report.Diffs) < opts.MaxDiffs {
+					report.Diffs = append(report.Diffs, diff)
+				}
+			}
+			if opts.DryRun {
+				continue
+			}
+			if err = repository.Posts.SetTags(ctx, retagged); err != nil {
+				return report, errors.New("Cannot re-tag posts: " + err.Error())
+			}
+		}
+		fmt.Printf("Re-tagged %d posts, %d changed\n", report.Scanned, report.Changed)
+	}
+
+	return report, nil
+}
+
+// Retag returns the post tagged with the current dictionaries, stamped with their version
+func Retag(post model.Post, version int64) model.Post {
+	matches := []model.TagMatch{}
+	for _, m := range post.Matches {
+		if !slices.Contains(refreshableFields, m.Field) {
+			matches = append(matches, m)
+		}
+	}
+	matches = append(matches, tags.Extract(model.FieldTitle, post.Title)...)
+	matches = append(matches, tags.Extract(model.FieldSummary, post.Summary)...)
+	matches = append(matches, tags.Extract(model.FieldBody, post.Body)...)
+
+	locations := tags.TagsOf(matches, model.TagKindLocation)
+	airlines := tags.TagsOf(matches, model.TagKindAirline)
+
+	// posts scrapped before matches were recorded cannot tell which tags came from other fields, so
+	// their tags are only ever added to
+	if len(post.Matches) == 0 {
+		locations = tags.Finest(collection.RemoveListDuplicates(append(locations, post.Locations...)))
+		airlines = collection.RemoveListDuplicates(append(airlines, post.Airlines...))
+	}
+
+	post.Matches = matches
+	post.Locations = locations
+	post.Airlines = airlines
+	post.DictVersion = version
+	return post
+}
+
+func diffOf(before model.Post, after model.Post) (Diff, bool) {
+	diff := Diff{
+		PostID:           before.ID.Hex(),
+		Title:            before.Title,
+		AddedLocations:   minus(after.Locations, before.Locations),
+		RemovedLocations: minus(before.Locations, after.Locations),
+		AddedAirlines:    minus(after.Airlines, before.Airlines),
+		RemovedAirlines:  minus(before.Airlines, after.Airlines),
+	}
+	changed := len(diff.AddedLocations)+len(diff.RemovedLocations)+len(diff.AddedAirlines)+len(diff.RemovedAirlines) > 0
+	return diff, changed
+}
+
+// elements of a missing from b
+func minus(a []string, b []string) []string {
+	output := []string{}
+	for _, item := range a {
+		if !slices.Contains(b, item) {
+			output = append(output, item)
+		}
+	}
+	return output
+}
+
+// String is the summary of the run followed by its diffs, one per line
+func (r Report) String() string {
+	lines := []string{}
+	for _, diff := range r.Diffs {
+		lines = append(lines, diff.String())
+	}
+	if r.Changed > len(r.Diffs) {
+		lines = append(lines, fmt.Sprintf("and %d more changed", r.Changed-len(r.Diffs)))
+	}
+	lines = append(lines, fmt.Sprintf("Scanned %d posts, %d changed (dictionary version %d, dry run: %v)",
+		r.Scanned, r.Changed, r.Version, r.DryRun))
+	return strings.Join(lines, "\n")
+}
+
+func (d Diff) String() string {
+	return fmt.Sprintf("%s %q locations +%v -%v airlines +%v -%v",
+		d.PostID, d.Title, d.AddedLocations, d.RemovedLocations, d.AddedAirlines, d.RemovedAirlines)
+}
@@ -0,0 +1,262 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/lock"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/robfig/cron/v3"
+)
+
+// how often triggers from the admin endpoints are looked for
+const pollInterval = 15 * time.Second
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrRunning  = errors.New("job is running")
+)
+
+type (
+	// Job runs on a cron expression (e.g. "*/30 * * * *" or "@hourly"), delayed by up to the jitter of the
+	// scheduler, on one instance at a time and once per scheduled time however many instances share it, unless
+	// the expression is an @every interval, whose times differ between instances. The context of Run is
+	// cancelled when the scheduler stops, or once another instance may run the job
+	Job struct {
+		Name     string
+		Schedule string
+		Run      func(ctx context.Context) error
+	}
+
+	Scheduler struct {
+		jitter  time.Duration
+		entries map[string]*entry
+		ctx     context.Context
+		cancel  context.CancelFunc
+		wg      sync.WaitGroup
+	}
+
+	entry struct {
+		job      Job
+		schedule cron.Schedule
+	}
+
+	// the key of the result of a run in its context, see SetResult
+	resultKey struct{}
+)
+
+func New(jitter time.Duration) *Scheduler {
+	return &Scheduler{jitter: jitter, entries: map[string]*entry{}}
+}
+
+// Add registers a job, to be run once the scheduler is started
+func (s *Scheduler) Add(job Job) error {
+	schedule, err := cron.ParseStandard(job.Schedule)
+	if err != nil {
+		return errors.New("Cannot parse schedule of " + job.Name + ": " + err.Error())
+	}
+	s.entries[job.Name] = &entry{job: job, schedule: schedule}
+	return nil
+}
+
+// Start runs every job on its schedule, and the jobs triggered from the admin endpoints, until Stop or until
+// ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+	s.wg.Add(1)
+	go s.poll()
+	log.Println("Scheduler started with", len(s.entries), "jobs")
+}
+
+// Stop stops scheduling jobs, cancels the jobs running and waits for them to return
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	for {
+		slot := e.schedule.Next(time.Now()).UTC()
+		next := slot
+		if s.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+		}
+		if err := repository.Jobs.SetSchedule(context.Background(), e.job.Name, e.job.Schedule, next.UTC()); err != nil {
+			log.Println(err.Error())
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			s.fire(e, slot)
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// poll runs the jobs triggered since the last poll. Triggers are cleared as they are picked up, so that
+// each is run once even when several schedulers share the database
+func (s *Scheduler) poll() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+
+		triggered, err := repository.Jobs.Triggered(s.ctx)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		for _, state := range triggered {
+			e, ok := s.entries[state.Name]
+			if !ok {
+				continue
+			}
+			cleared, err := repository.Jobs.ClearTrigger(s.ctx, state.Name, *state.TriggeredAt)
+			if err != nil || !cleared {
+				continue
+			}
+			log.Println("Running triggered job", state.Name)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.run(e)
+			}()
+		}
+	}
+}
+
+// fire runs the job at the time it is scheduled at, unless another instance has claimed that time. Instances
+// fire on their own jitter, so the first one to claim it runs the job
+func (s *Scheduler) fire(e *entry, slot time.Time) {
+	claimed, err := repository.Jobs.ClaimSlot(s.ctx, e.job.Name, slot)
+	if err != nil {
+		log.Println("Cannot run job", e.job.Name, err.Error())
+		return
+	}
+	if !claimed {
+		log.Println("Skipping job", e.job.Name, "as another instance runs it for", slot.Format(time.RFC3339))
+		return
+	}
+	s.run(e)
+}
+
+// run skips the job if it is still running from an earlier schedule or trigger, on this instance or another
+func (s *Scheduler) run(e *entry) {
+	lease, err := lock.Acquire(s.ctx, leaseOf(e.job.Name))
+	if errors.Is(err, lock.ErrHeld) {
+		log.Println("Skipping job", e.job.Name, "as it is still running:", err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("Cannot run job", e.job.Name, err.Error())
+		return
+	}
+	defer lease.Release()
+
+	start := time.Now().UTC()
+	log.Println("Running job", e.job.Name)
+
+	var result atomic.Value
+	err = e.job.Run(context.WithValue(lease.Context(), resultKey{}, &result))
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		if stackErr, ok := err.(*errors.Error); ok {
+			log.Println("Job", e.job.Name, "failed:", stackErr.ErrorStack())
+		} else {
+			log.Println("Job", e.job.Name, "failed:", err.Error())
+		}
+	}
+	// with its own context, so that runs cancelled by Stop are still recorded as finished
+	summary, _ := result.Load().(string)
+	if err = repository.Jobs.SetLastRun(context.Background(), e.job.Name, start, time.Since(start), lastError, summary); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// SetResult records what the run of the job whose context ctx is reported, e.g. a summary shown by the admin
+// endpoints. Outside jobs, it does nothing
+func SetResult(ctx context.Context, result string) {
+	if v, ok := ctx.Value(resultKey{}).(*atomic.Value); ok {
+		v.Store(result)
+	}
+}
+
+// the lease held while the job runs
+func leaseOf(name string) string {
+	return "job:" + name
+}
+
+// Jobs returns the state of the jobs scheduled, by name
+func Jobs(ctx context.Context) ([]model.JobState, error) {
+	jobs, err := repository.Jobs.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = setRunning(ctx, jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func setRunning(ctx context.Context, jobs []model.JobState) error {
+	names := []string{}
+	for _, job := range jobs {
+		names = append(names, leaseOf(job.Name))
+	}
+	held, err := lock.Held(ctx, names...)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if lease, ok := held[leaseOf(jobs[i].Name)]; ok {
+			jobs[i].Running, jobs[i].RunningSince, jobs[i].RunningOn = true, &lease.AcquiredAt, lease.Holder
+		}
+	}
+	return nil
+}
+
+// Trigger asks the schedulers to run the job on their next poll
+func Trigger(ctx context.Context, name string) (model.JobState, error) {
+	state, err := repository.Jobs.Get(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return state, errors.WrapPrefix(ErrNotFound, name, 0)
+	}
+	if err != nil {
+		return state, err
+	}
+	jobs := []model.JobState{state}
+	if err = setRunning(ctx, jobs); err != nil {
+		return state, err
+	}
+	state = jobs[0]
+	if state.Running {
+		return state, errors.WrapPrefix(ErrRunning, name, 0)
+	}
+
+	now := time.Now().UTC()
+	if err = repository.Jobs.Trigger(ctx, name, now); err != nil {
+		return state, err
+	}
+	state.TriggeredAt = &now
+	return state, nil
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffyfung/flight-info-agg/pkg/lock"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+)
+
+// a schedule far enough that the loops never fire during a test
+const never = "0 0 1 1 *"
+
+// started returns a scheduler of a job counting its runs, stopped once the test is done
+func started(t *testing.T, runs *atomic.Int64) *Scheduler {
+	s := New(0)
+	err := s.Add(Job{Name: "test", Schedule: never, Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background())
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestRunSkipsJobRunning(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	var runs atomic.Int64
+	s := started(t, &runs)
+	if err := repository.Jobs.SetSchedule(ctx, "test", never, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	// as if run by another instance
+	lease, err := lock.Acquire(ctx, leaseOf("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.run(s.entries["test"])
+	if runs.Load() != 0 {
+		t.Errorf("got %d runs while running elsewhere, want 0", runs.Load())
+	}
+	jobs, err := Jobs(ctx)
+	if err != nil || len(jobs) != 1 || !jobs[0].Running || jobs[0].LastRun != nil {
+		t.Errorf("got %+v, %v, want the job running and never run here", jobs, err)
+	}
+	if _, err = Trigger(ctx, "test"); !errors.Is(err, ErrRunning) {
+		t.Errorf("trigger: got %v, want ErrRunning", err)
+	}
+
+	lease.Release()
+	s.run(s.entries["test"])
+	if runs.Load() != 1 {
+		t.Errorf("got %d runs once released, want 1", runs.Load())
+	}
+	jobs, err = Jobs(ctx)
+	if err != nil || len(jobs) != 1 || jobs[0].Running || jobs[0].LastRun == nil {
+		t.Errorf("got %+v, %v, want the job run", jobs, err)
+	}
+}
+
+func TestFireClaimsSlot(t *testing.T) {
+	repository.UseMemory()
+	var runs atomic.Int64
+	// instances sharing the schedule
+	a, b := started(t, &runs), started(t, &runs)
+	if err := repository.Jobs.SetSchedule(context.Background(), "test", never, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	slot := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fires := []struct {
+		name string
+		s    *Scheduler
+		slot time.Time
+		want int64
+	}{
+		{"first to claim", a, slot, 1},
+		{"slot claimed", b, slot, 1},
+		{"slot claimed by itself", a, slot, 1},
+		{"next slot", b, slot.AddDate(1, 0, 0), 2},
+		{"next slot claimed", a, slot.AddDate(1, 0, 0), 2},
+		{"earlier slot", a, slot, 2},
+	}
+	for _, f := range fires {
+		f.s.fire(f.s.entries["test"], f.slot)
+		if got := runs.Load(); got != f.want {
+			t.Errorf("%s: got %d runs, want %d", f.name, got, f.want)
+		}
+	}
+}
+
+func TestRunKeepsResult(t *testing.T) {
+	repository.UseMemory()
+	ctx := context.Background()
+	s := New(0)
+	err := s.Add(Job{Name: "report", Schedule: never, Run: func(ctx context.Context) error {
+		SetResult(ctx, "scanned 3 posts")
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start(ctx)
+	defer s.Stop()
+
+	s.run(s.entries["report"])
+	job, err := repository.Jobs.Get(ctx, "report")
+	if err != nil || job.LastResult != "scanned 3 posts" || job.LastError != "" {
+		t.Errorf("got %+v, %v, want the result of the run", job, err)
+	}
+	// outside jobs
+	SetResult(ctx, "ignored")
+}
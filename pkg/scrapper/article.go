@@ -0,0 +1,157 @@
+package scrapper
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-errors/errors"
+	colly "github.com/gocolly/colly/v2"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+// labels of structured fields are short, e.g. 航點, 航空公司, 出發日期
+const maxFieldLabel = 6
+
+type article struct {
+	body     string
+	image    string
+	author   string
+	fields   map[string]string
+	failures []error
+}
+
+// parseArticle extracts the full post from its article page, as the article spec of its source says
+func parseArticle(spec model.SourceSpec, page *goquery.Selection) article {
+	content := page.Find(spec.Article.Body)
+	result := article{fields: fieldsOf(content.Find("p"))}
+	if spec.Article.Image != nil {
+		result.image = valueOf(page, *spec.Article.Image)
+	}
+	if spec.Article.Author != nil {
+		result.author = valueOf(page, *spec.Article.Author)
+	}
+	result.body = bodyOf(content)
+	if result.body == "" {
+		result.failures = append(result.failures, errors.New("No "+string(spec.Name)+" article body matched"))
+	}
+	return result
+}
+
+// bodyOf joins the paragraphs and list items of the content, one per line
+func bodyOf(content *goquery.Selection) string {
+	lines := []string{}
+	content.Find("p, li").Each(func(_ int, s *goquery.Selection) {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	})
+	return strings.Join(lines, "\n")
+}
+
+// fieldsOf reads the paragraphs written as "label：value"
+func fieldsOf(paragraphs *goquery.Selection) map[string]string {
+	fields := map[string]string{}
+	paragraphs.Each(func(_ int, s *goquery.Selection) {
+		label, value, ok := strings.Cut(strings.TrimSpace(s.Text()), "：")
+		if !ok {
+			label, value, ok = strings.Cut(strings.TrimSpace(s.Text()), ":")
+		}
+		label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		if !ok || label == "" || value == "" || utf8.RuneCountInString(label) > maxFieldLabel {
+			return
+		}
+		if _, seen := fields[label]; !seen {
+			fields[label] = value
+		}
+	})
+	return fields
+}
+
+// fetchArticles fetches the article page of the posts, within the rate limits of newCollector, and adds
+// the body, image, author and fields to the posts along with the tags found in them. Posts whose article
+// cannot be fetched or parsed are kept as scrapped from the listing
+func fetchArticles(ctx context.Context, posts []model.Post) []model.Post {
+	c := newCollector(ctx, func(r *colly.Response, err error) {
+		log.Println("Cannot fetch article", r.Request.URL, err.Error())
+	}, colly.Async(true))
+	// articles are fetched once, as only those of new posts are, hence not kept by the shared transport
+	c.WithTransport(http.DefaultTransport)
+
+	var mu sync.Mutex
+
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		mu.Lock()
+		defer mu.Unlock()
+		// kept in the context, as articles may redirect
+		i, ok := h.Request.Ctx.GetAny("post").(int)
+		if !ok {
+			return
+		}
+		spec, ok := specOf(posts[i].Source)
+		if !ok || spec.Article == nil {
+			return
+		}
+		result := parseArticle(spec, h.DOM)
+		if len(result.failures) > 0 {
+			log.Println("Cannot parse article", h.Request.URL, result.failures[0].Error())
+			return
+		}
+		posts[i] = withArticle(posts[i], spec.Article.Fields, result)
+	})
+
+	for i, post := range posts {
+		if spec, ok := specOf(post.Source); !ok || spec.Article == nil {
+			continue
+		}
+		reqCtx := colly.NewContext()
+		reqCtx.Put("post", i)
+		if err := c.Request("GET", post.URL, nil, reqCtx, nil); err != nil {
+			log.Println("Cannot fetch article", post.URL, err.Error())
+		}
+	}
+	c.Wait()
+
+	return posts
+}
+
+// withArticle adds the article to the post and re-computes its tags. Fields already tagged from the
+// listing, as on flyagain, are not tagged again
+func withArticle(post model.Post, fieldsToTag []model.LabelledField, a article) model.Post {
+	post.Body, post.Image, post.Author = a.body, a.image, a.author
+	if len(a.fields) > 0 {
+		post.Fields = a.fields
+	}
+
+	tagged := map[string]bool{}
+	for _, m := range post.Matches {
+		tagged[m.Field] = true
+	}
+	matches := append(post.Matches, tags.Extract(model.FieldBody, a.body)...)
+	labels := []string{}
+	for label := range a.fields {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, f := range fieldsToTag {
+		if tagged[f.Field] {
+			continue
+		}
+		for _, label := range labels {
+			if strings.Contains(label, f.Label) {
+				matches = append(matches, tags.Extract(f.Field, a.fields[label])...)
+			}
+		}
+	}
+
+	post.Matches = matches
+	post.Locations = tags.TagsOf(matches, model.TagKindLocation)
+	post.Airlines = tags.TagsOf(matches, model.TagKindAirline)
+	return post
+}
@@ -0,0 +1,278 @@
+package scrapper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	colly "github.com/gocolly/colly/v2"
+	"github.com/jeffyfung/flight-info-agg/config"
+)
+
+// newCollector returns a collector set up as config.Cfg.Scrapper says: an identifiable User-Agent,
+// robots.txt respected, rate limits per source domain, request timeouts, conditional requests and retries
+// of 5xx and network errors with backoff. fail is called once a request has failed for good. Requests are
+// aborted, and not retried, once ctx is done
+func newCollector(ctx context.Context, fail func(r *colly.Response, err error), options ...colly.CollectorOption) *colly.Collector {
+	cfg := config.Cfg.Scrapper
+	c := colly.NewCollector(append([]colly.CollectorOption{colly.UserAgent(cfg.UserAgent)}, options...)...)
+	c.IgnoreRobotsTxt = cfg.IgnoreRobotsTxt
+	c.SetRequestTimeout(cfg.Timeout)
+	c.WithTransport(sharedTransport())
+
+	// colly shares a rule between the domains it matches, hence a rule per source
+	for _, s := range sources() {
+		c.Limit(&colly.LimitRule{DomainGlob: "*" + s.domain, Parallelism: max(cfg.Parallelism, 1), Delay: cfg.Delay})
+	}
+	c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: max(cfg.Parallelism, 1), Delay: cfg.Delay})
+
+	c.OnRequest(func(r *colly.Request) {
+		if ctx.Err() != nil {
+			r.Abort()
+		}
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		attempt, _ := r.Request.Ctx.GetAny("attempt").(int)
+		if retryable(r) && attempt < cfg.Retries && ctx.Err() == nil {
+			backoff := cfg.RetryBackoff * time.Duration(1<<attempt)
+			log.Println("Retrying", r.Request.URL, "in", backoff, "after", err.Error())
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			r.Request.Ctx.Put("attempt", attempt+1)
+			// synchronous collectors return the error of the retry, which has been handled by then, i.e.
+			// retried again or failed
+			retryErr := r.Request.Retry()
+			failed, _ := r.Request.Ctx.GetAny("failed").(bool)
+			retried, _ := r.Request.Ctx.GetAny("attempt").(int)
+			if retryErr == nil || failed || retried > attempt+1 {
+				return
+			}
+		}
+		r.Request.Ctx.Put("failed", true)
+		fail(r, err)
+	})
+	return c
+}
+
+// network errors have no status code
+func retryable(r *colly.Response) bool {
+	return r.StatusCode == 0 || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+}
+
+const (
+	// pages not revalidated for this long are dropped, as their source likely moved them
+	cacheMaxAge = 7 * 24 * time.Hour
+	// the most pages kept, the least recently fetched dropped first
+	cacheMaxPages = 500
+)
+
+var (
+	transportOnce sync.Once
+	transport     *conditionalTransport
+)
+
+// sharedTransport outlives the runs of a process, so that the listings and feeds a run keeps are revalidated
+// by the next one. Pages are kept under the user cache dir unless config.Cfg.Scrapper.CacheDir says otherwise,
+// so that they also outlive one-off processes such as cron runs. Articles, fetched once, are not kept, see
+// fetchArticles
+func sharedTransport() *conditionalTransport {
+	transportOnce.Do(func() {
+		dir := config.Cfg.Scrapper.CacheDir
+		if dir == "" {
+			userDir, err := os.UserCacheDir()
+			if err != nil {
+				log.Println("Cannot find a cache dir, keeping pages in memory: " + err.Error())
+			} else {
+				dir = filepath.Join(userDir, "flight-info-agg", "pages")
+			}
+		}
+		transport = &conditionalTransport{next: http.DefaultTransport, dir: dir, maxAge: cacheMaxAge, maxPages: cacheMaxPages}
+	})
+	return transport
+}
+
+// conditionalTransport keeps the pages fetched with their ETag and Last-Modified and revalidates them
+// with If-None-Match and If-Modified-Since. A 304 is answered with the page kept, so that callers parse
+// it as usual. Pages are kept in dir when set, in memory otherwise, see sharedTransport, for up to maxAge
+// since last fetched and maxPages pages. Zero bounds keep pages forever
+type conditionalTransport struct {
+	next     http.RoundTripper
+	dir      string
+	maxAge   time.Duration
+	maxPages int
+	mu       sync.Mutex
+	memory   map[string]cachedPage
+}
+
+type cachedPage struct {
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	ContentType  string    `json:"content_type"`
+	Body         []byte    `json:"body"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	cached, ok := t.get(key)
+	if ok {
+		req = req.Clone(req.Context())
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotModified && ok {
+		res.Body.Close()
+		res.StatusCode, res.Status = http.StatusOK, "200 OK"
+		res.Header.Set("Content-Type", cached.ContentType)
+		res.Body = io.NopCloser(bytes.NewReader(cached.Body))
+		res.ContentLength = int64(len(cached.Body))
+		cached.FetchedAt = time.Now().UTC()
+		t.put(key, cached)
+		return res, nil
+	}
+
+	etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if res.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return res, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	t.put(key, cachedPage{ETag: etag, LastModified: lastModified, ContentType: res.Header.Get("Content-Type"), Body: body, FetchedAt: time.Now().UTC()})
+	return res, nil
+}
+
+func (t *conditionalTransport) get(key string) (cachedPage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var page cachedPage
+	if t.dir == "" {
+		page = t.memory[key]
+	} else if b, err := os.ReadFile(t.path(key)); err == nil {
+		// pages that cannot be read are fetched again
+		json.Unmarshal(b, &page)
+	}
+	// pages kept before they had a fetch time are expired too
+	if page.Body == nil || t.expired(page.FetchedAt) {
+		return page, false
+	}
+	return page, true
+}
+
+func (t *conditionalTransport) put(key string, page cachedPage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dir == "" {
+		if t.memory == nil {
+			t.memory = map[string]cachedPage{}
+		}
+		t.memory[key] = page
+		t.pruneMemory()
+		return
+	}
+
+	b, err := json.Marshal(page)
+	if err == nil {
+		err = os.MkdirAll(t.dir, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(t.path(key), b, 0644)
+	}
+	// for pruneDir
+	if err == nil {
+		err = os.Chtimes(t.path(key), page.FetchedAt, page.FetchedAt)
+	}
+	if err != nil {
+		log.Println("Cannot cache page", key, err.Error())
+	}
+	t.pruneDir()
+}
+
+func (t *conditionalTransport) expired(at time.Time) bool {
+	return t.maxAge > 0 && time.Since(at) > t.maxAge
+}
+
+func (t *conditionalTransport) pruneMemory() {
+	for key, page := range t.memory {
+		if t.expired(page.FetchedAt) {
+			delete(t.memory, key)
+		}
+	}
+	for t.maxPages > 0 && len(t.memory) > t.maxPages {
+		oldest := ""
+		for key, page := range t.memory {
+			if oldest == "" || page.FetchedAt.Before(t.memory[oldest].FetchedAt) {
+				oldest = key
+			}
+		}
+		delete(t.memory, oldest)
+	}
+}
+
+// pruneDir removes the pages expired or in excess of maxPages, by the time of their files, i.e. when fetched
+func (t *conditionalTransport) pruneDir() {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		log.Println("Cannot prune cached pages: " + err.Error())
+		return
+	}
+	type file struct {
+		path      string
+		fetchedAt time.Time
+	}
+	files := []file{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		files = append(files, file{filepath.Join(t.dir, entry.Name()), info.ModTime()})
+	}
+	// latest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].fetchedAt.After(files[j].fetchedAt)
+	})
+	for i, f := range files {
+		if !t.expired(f.fetchedAt) && (t.maxPages <= 0 || i < t.maxPages) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Println("Cannot prune cached page: " + err.Error())
+		}
+	}
+}
+
+func (t *conditionalTransport) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,71 @@
+package scrapper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionalTransport(t *testing.T) {
+	requests, revalidated := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html>page</html>")
+	}))
+	defer server.Close()
+
+	for _, dir := range []string{"", t.TempDir()} {
+		requests, revalidated = 0, 0
+		transport := &conditionalTransport{next: http.DefaultTransport, dir: dir}
+		// a transport per run would never revalidate, hence the shared one
+		for run := 0; run < 2; run++ {
+			res, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || string(body) != "<html>page</html>" {
+				t.Errorf("dir %q run %d: got %d %q", dir, run, res.StatusCode, body)
+			}
+		}
+		if requests != 2 || revalidated != 1 {
+			t.Errorf("dir %q: got %d requests, %d revalidated, want 2 and 1", dir, requests, revalidated)
+		}
+	}
+}
+
+func TestConditionalTransportBounds(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		transport := &conditionalTransport{next: http.DefaultTransport, dir: dir, maxAge: time.Hour, maxPages: 2}
+		now := time.Now().UTC()
+		pages := []struct {
+			key       string
+			fetchedAt time.Time
+		}{
+			{"https://example.com/1", now.Add(-2 * time.Minute)},
+			{"https://example.com/2", now.Add(-time.Minute)},
+			{"https://example.com/3", now},
+			{"https://example.com/expired", now.Add(-2 * time.Hour)},
+		}
+		for _, p := range pages {
+			transport.put(p.key, cachedPage{ETag: `"v1"`, Body: []byte("page"), FetchedAt: p.fetchedAt})
+		}
+
+		kept := map[string]bool{"https://example.com/1": false, "https://example.com/2": true, "https://example.com/3": true, "https://example.com/expired": false}
+		for key, want := range kept {
+			if _, got := transport.get(key); got != want {
+				t.Errorf("dir %q: got %s kept %v, want %v", dir, key, got, want)
+			}
+		}
+	}
+}
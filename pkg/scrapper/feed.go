@@ -0,0 +1,284 @@
+package scrapper
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+	"golang.org/x/net/html/charset"
+)
+
+type (
+	rssFeed struct {
+		Channel struct {
+			Items []rssItem `xml:"item"`
+		} `xml:"channel"`
+	}
+
+	rssItem struct {
+		Title       string   `xml:"title"`
+		Link        string   `xml:"link"`
+		GUID        string   `xml:"guid"`
+		Description string   `xml:"description"`
+		Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		PubDate     string   `xml:"pubDate"`
+		Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Author      string   `xml:"author"`
+		Categories  []string `xml:"category"`
+		Enclosures  []struct {
+			URL  string `xml:"url,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"enclosure"`
+		Media []struct {
+			URL    string `xml:"url,attr"`
+			Medium string `xml:"medium,attr"`
+		} `xml:"http://search.yahoo.com/mrss/ content"`
+	}
+
+	atomFeed struct {
+		Links   []atomLink  `xml:"link"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomEntry struct {
+		Title     atomText   `xml:"title"`
+		Links     []atomLink `xml:"link"`
+		ID        string     `xml:"id"`
+		Summary   atomText   `xml:"summary"`
+		Content   atomText   `xml:"content"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Author    struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+		Categories []struct {
+			Term  string `xml:"term,attr"`
+			Label string `xml:"label,attr"`
+		} `xml:"category"`
+	}
+
+	atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	}
+
+	// text, html or xhtml, the latter kept as markup
+	atomText struct {
+		Type  string `xml:"type,attr"`
+		Text  string `xml:",chardata"`
+		Inner string `xml:",innerxml"`
+	}
+)
+
+// layouts of the RSS pubDate, which is meant to be RFC 822 but seldom is to the letter
+var rssDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+// parseFeed parses an RSS 2.0 or Atom feed into posts of the source. Titles, summaries and categories
+// are tagged as on listings, and the full content, if the feed has it, is kept as the body. Atom feeds
+// may link to their next page (RFC 5005)
+func parseFeed(source model.DataSource, body []byte) parsed {
+	result := parsed{posts: []model.Post{}}
+
+	root, err := rootElement(body)
+	if err != nil {
+		result.failures = append(result.failures, errors.New("Cannot read feed: "+err.Error()))
+		return result
+	}
+
+	switch root {
+	case "rss":
+		var feed rssFeed
+		if err = decodeFeed(body, &feed); err != nil {
+			result.failures = append(result.failures, errors.New("Cannot decode RSS feed: "+err.Error()))
+			return result
+		}
+		for _, item := range feed.Channel.Items {
+			result.elements++
+			post, err := rssPost(source, item)
+			if err != nil {
+				result.failures = append(result.failures, err)
+				continue
+			}
+			result.posts = append(result.posts, post)
+		}
+	case "feed":
+		var feed atomFeed
+		if err = decodeFeed(body, &feed); err != nil {
+			result.failures = append(result.failures, errors.New("Cannot decode Atom feed: "+err.Error()))
+			return result
+		}
+		for _, entry := range feed.Entries {
+			result.elements++
+			post, err := atomPost(source, entry)
+			if err != nil {
+				result.failures = append(result.failures, err)
+				continue
+			}
+			result.posts = append(result.posts, post)
+		}
+		result.next = linkOf(feed.Links, "next", "")
+	default:
+		result.failures = append(result.failures, errors.New("Unsupported feed format <"+root+">"))
+	}
+	return result
+}
+
+func rssPost(source model.DataSource, item rssItem) (model.Post, error) {
+	var pubDate time.Time
+	var err error
+	for _, layout := range rssDateLayouts {
+		if pubDate, err = time.Parse(layout, strings.TrimSpace(item.PubDate)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return model.Post{}, errors.New("Cannot parse RSS date " + item.PubDate + ": " + err.Error())
+	}
+
+	URL := strings.TrimSpace(item.Link)
+	if URL == "" {
+		URL = strings.TrimSpace(item.GUID)
+	}
+
+	image := ""
+	for _, e := range item.Enclosures {
+		if strings.HasPrefix(e.Type, "image/") {
+			image = e.URL
+			break
+		}
+	}
+	for _, m := range item.Media {
+		if image == "" && (m.Medium == "" || m.Medium == "image") {
+			image = m.URL
+		}
+	}
+
+	author := item.Creator
+	if author == "" {
+		author = item.Author
+	}
+
+	return feedPost(source, strings.TrimSpace(item.Title), textOf(item.Description), bodyTextOf(item.Content),
+		URL, pubDate, image, author, item.Categories), nil
+}
+
+func atomPost(source model.DataSource, entry atomEntry) (model.Post, error) {
+	dateStr := entry.Published
+	if dateStr == "" {
+		dateStr = entry.Updated
+	}
+	pubDate, err := time.Parse(time.RFC3339, strings.TrimSpace(dateStr))
+	if err != nil {
+		return model.Post{}, errors.New("Cannot parse Atom date " + dateStr + ": " + err.Error())
+	}
+
+	URL := linkOf(entry.Links, "alternate", "")
+	if URL == "" {
+		URL = strings.TrimSpace(entry.ID)
+	}
+
+	categories := []string{}
+	for _, c := range entry.Categories {
+		if c.Label != "" {
+			categories = append(categories, c.Label)
+		} else {
+			categories = append(categories, c.Term)
+		}
+	}
+
+	return feedPost(source, textOf(entry.Title.value()), textOf(entry.Summary.value()), bodyTextOf(entry.Content.value()),
+		URL, pubDate, linkOf(entry.Links, "enclosure", "image/"), strings.TrimSpace(entry.Author.Name), categories), nil
+}
+
+func feedPost(source model.DataSource, title string, summary string, body string, URL string, pubDate time.Time, image string, author string, categories []string) model.Post {
+	matches := []model.TagMatch{}
+	matches = append(matches, tags.Extract(model.FieldTitle, title)...)
+	matches = append(matches, tags.Extract(model.FieldSummary, summary)...)
+	for _, category := range categories {
+		matches = append(matches, tags.Extract(model.FieldCategory, category)...)
+	}
+	matches = append(matches, tags.Extract(model.FieldBody, body)...)
+
+	post := newPost(source, title, summary, URL, pubDate, matches)
+	post.Body, post.Image, post.Author = body, image, strings.TrimSpace(author)
+	return post
+}
+
+// rootElement returns the name of the first element, rss or feed
+func rootElement(body []byte) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func decodeFeed(body []byte, feed any) error {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = charset.NewReaderLabel
+	// feeds in the wild use HTML entities such as &nbsp;
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	return d.Decode(feed)
+}
+
+// linkOf returns the first link of the relation, alternate being the default relation of Atom
+func linkOf(links []atomLink, rel string, typePrefix string) string {
+	for _, l := range links {
+		r := l.Rel
+		if r == "" {
+			r = "alternate"
+		}
+		if r == rel && strings.HasPrefix(l.Type, typePrefix) {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
+}
+
+func (t atomText) value() string {
+	if t.Type == "xhtml" {
+		return t.Inner
+	}
+	return t.Text
+}
+
+// textOf strips the markup of feed HTML and collapses its whitespace
+func textOf(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}
+
+// bodyTextOf keeps the paragraphs of feed HTML, one per line, as bodyOf does for article pages
+func bodyTextOf(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return textOf(s)
+	}
+	if body := bodyOf(doc.Selection); body != "" {
+		return body
+	}
+	return textOf(s)
+}
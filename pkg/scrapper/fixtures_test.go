@@ -0,0 +1,153 @@
+package scrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-errors/errors"
+	model "github.com/jeffyfung/flight-info-agg/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the goldens of the saved pages from the parsers")
+
+// TestFixtures runs the parsers over the saved pages and compares the output with their goldens. Rewrite the
+// goldens with -update once a change of output is intended
+func TestFixtures(t *testing.T) {
+	pages, err := fixturePages("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatal("no fixtures under testdata")
+	}
+	for _, page := range pages {
+		t.Run(strings.TrimPrefix(page, "testdata/"), func(t *testing.T) {
+			got, err := parseFixture(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err = os.WriteFile(goldenOf(page), got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenOf(page))
+			if err != nil {
+				t.Fatalf("no golden, run with -update: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error(firstDiff(got, want))
+			}
+		})
+	}
+}
+
+func firstDiff(got []byte, want []byte) string {
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < max(len(gotLines), len(wantLines)); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			return fmt.Sprintf("line %d is %q, golden has %q", i+1, strings.TrimSpace(g), strings.TrimSpace(w))
+		}
+	}
+	return "output differs from golden"
+}
+
+type golden struct {
+	Next  string       `json:"next"`
+	Posts []model.Post `json:"posts"`
+}
+
+// fixturePages lists the pages saved under dir, one directory per source (e.g. testdata/flyday/listing.html),
+// each with its golden JSON next to it
+func fixturePages(dir string) ([]string, error) {
+	pages, err := filepath.Glob(filepath.Join(dir, "*", "*.html"))
+	if err != nil {
+		return nil, errors.New("Cannot list fixtures: " + err.Error())
+	}
+	feeds, err := filepath.Glob(filepath.Join(dir, "*", "*.xml"))
+	if err != nil {
+		return nil, errors.New("Cannot list fixtures: " + err.Error())
+	}
+	return append(pages, feeds...), nil
+}
+
+// parseFixture runs the parser of the page and returns its output as golden JSON. Listings and feeds (*.xml)
+// keep their posts and next page link, articles (article*.html) the post they make
+func parseFixture(page string) ([]byte, error) {
+	source := model.DataSource(filepath.Base(filepath.Dir(page)))
+	b, err := os.ReadFile(page)
+	if err != nil {
+		return nil, errors.New("Cannot read fixture: " + err.Error())
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.New("Cannot read fixture " + page + ": " + err.Error())
+	}
+
+	var output any
+	switch {
+	case filepath.Ext(page) == ".xml":
+		output, err = checkParsed(parseFeed(source, b))
+	case strings.HasPrefix(filepath.Base(page), "article"):
+		output, err = parseArticleFixture(source, doc.Selection)
+	default:
+		output, err = parseListingFixture(source, doc.Selection)
+	}
+	if err != nil {
+		return nil, err
+	}
+	got, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return nil, errors.New("Cannot marshal posts: " + err.Error())
+	}
+	return append(got, '\n'), nil
+}
+
+func goldenOf(page string) string {
+	return strings.TrimSuffix(page, filepath.Ext(page)) + ".json"
+}
+
+func parseListingFixture(source model.DataSource, page *goquery.Selection) (golden, error) {
+	spec, ok := specOf(source)
+	if !ok {
+		return golden{}, errors.New("no spec for " + string(source))
+	}
+	return checkParsed(parseSpec(spec, page))
+}
+
+func checkParsed(result parsed) (golden, error) {
+	if result.elements == 0 {
+		return golden{}, errors.New("no post elements matched")
+	}
+	if len(result.failures) > 0 {
+		return golden{}, result.failures[0]
+	}
+	return golden{Next: result.next, Posts: result.posts}, nil
+}
+
+func parseArticleFixture(source model.DataSource, page *goquery.Selection) (model.Post, error) {
+	spec, ok := specOf(source)
+	if !ok || spec.Article == nil {
+		return model.Post{}, errors.New("no article spec for " + string(source))
+	}
+	result := parseArticle(spec, page)
+	if len(result.failures) > 0 {
+		return model.Post{}, result.failures[0]
+	}
+	return withArticle(model.Post{Source: source}, spec.Article.Fields, result), nil
+}
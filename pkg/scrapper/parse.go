@@ -0,0 +1,34 @@
+package scrapper
+
+import (
+	"time"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+// parsed is what a page of a source gives, see parseSpec and parseFeed. Parsing does not fetch anything,
+// so that it can run over saved pages, see testdata. The elements matched by the post selector are
+// counted, so that markup changes can be told apart from quiet days. next is the link to the page of
+// older posts, if any
+type parsed struct {
+	posts    []model.Post
+	elements int
+	failures []error
+	next     string
+}
+
+// CreatedAt is left to the caller, as parsed posts are compared against goldens
+func newPost(source model.DataSource, title string, summary string, URL string, pubDate time.Time, matches []model.TagMatch) model.Post {
+	return model.Post{
+		Title:       title,
+		Summary:     summary,
+		Locations:   tags.TagsOf(matches, model.TagKindLocation),
+		Airlines:    tags.TagsOf(matches, model.TagKindAirline),
+		Matches:     matches,
+		DictVersion: tags.Version(),
+		URL:         URL,
+		PubDate:     pubDate,
+		Source:      source,
+	}
+}
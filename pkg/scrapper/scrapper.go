@@ -1,286 +1,384 @@
 package scrapper
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"strings"
+	"net/url"
+	"slices"
+	"sort"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/go-errors/errors"
 	colly "github.com/gocolly/colly/v2"
+	"github.com/jeffyfung/flight-info-agg/config"
 	model "github.com/jeffyfung/flight-info-agg/models"
 	"github.com/jeffyfung/flight-info-agg/pkg/collection"
-	"github.com/jeffyfung/flight-info-agg/pkg/database/mongoDB"
-	"github.com/jeffyfung/flight-info-agg/pkg/languages"
-	"github.com/jeffyfung/flight-info-agg/pkg/tags"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jeffyfung/flight-info-agg/pkg/health"
+	"github.com/jeffyfung/flight-info-agg/pkg/lock"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
 )
 
-const (
-	flydayURL   string = "https://flyday.hk/category/%e6%a9%9f%e7%a5%a8%e5%84%aa%e6%83%a0-tickets-promotions/"
-	flyAgainURL string = "https://flyagain.la/"
-)
-
-type result struct {
-	posts []model.Post
-	error error
-}
-
-func Scrap() ([]model.Post, error) {
+// listing pages visited per source on a run. Posts further back are left to backfills
+const maxPages = 5
 
-	lastScrapDate, err := getLastScrapDate()
-	if err != nil {
-		return nil, errors.New("Cannot get lastScrapDate" + err.Error())
+type (
+	result struct {
+		source  model.DataSource
+		posts   []model.Post
+		healthy bool
+		error   error
 	}
 
-	ch := make(chan result)
-	go scrapFlyday(ch, lastScrapDate)
-	go scrapFlyAgain(ch, lastScrapDate)
+	source struct {
+		name   model.DataSource
+		url    string
+		domain string
+		spec   model.SourceSpec
+		feed   bool // RSS or Atom, parsed by parseFeed rather than parseSpec
+	}
+)
 
-	posts := []model.Post{}
-	for i := 0; i < 2; i++ {
-		scrappedPosts, ok := <-ch
-		if !ok {
-			return nil, errors.New("Cannot get posts from channel" + err.Error())
+// sources returns the HTML sources of the specs enabled and the feeds of config.Cfg.Scrapper.Feeds, by
+// name. Feeds named as an HTML source or with an invalid URL are skipped
+func sources() []source {
+	output := []source{}
+	for _, spec := range currentSpecs() {
+		u, err := url.Parse(spec.URL)
+		if spec.Disabled || err != nil {
+			continue
 		}
-		posts = append(posts, scrappedPosts.posts...)
+		output = append(output, source{name: spec.Name, url: spec.URL, domain: u.Hostname(), spec: spec})
 	}
 
-	if len(posts) > 0 {
-		_, err = mongoDB.InsertBulkToCollection[model.Post]("posts", posts)
-		if err != nil {
-			return nil, errors.New("Cannot insert to posts table: " + err.Error())
+	names := []string{}
+	for name := range config.Cfg.Scrapper.Feeds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		feedURL := config.Cfg.Scrapper.Feeds[name]
+		u, err := url.Parse(feedURL)
+		if err != nil || u.Hostname() == "" {
+			log.Println("Skipping feed", name, "with invalid URL", feedURL)
+			continue
 		}
-		fmt.Printf("Logged %v new posts\n", len(posts))
+		if slices.ContainsFunc(output, func(s source) bool { return string(s.name) == name }) {
+			log.Println("Skipping feed", name, "named as another source")
+			continue
+		}
+		output = append(output, source{name: model.DataSource(name), url: feedURL, domain: u.Hostname(), feed: true})
 	}
+	return output
+}
 
-	err = updateLastScrapDate()
-	if err != nil {
-		return nil, errors.New("Cannot update system info: " + err.Error())
+// Sources returns the names of the sources scrapped, by name
+func Sources() []model.DataSource {
+	output := []model.DataSource{}
+	for _, s := range sources() {
+		output = append(output, s.name)
 	}
-
-	return posts, nil
+	return output
 }
 
-func scrapFlyday(ch chan result, lastScrapDate time.Time) {
-	log.Println("Start scrapping flyday")
-	c := colly.NewCollector(
-		colly.AllowedDomains("flyday.hk"),
-	)
+// Options narrow a scrape. The zero value scrapes every source since its watermark
+type Options struct {
+	Sources []model.DataSource // all by default
+	// crawl back to since rather than to the watermarks, as backfills do. Watermarks and source health are
+	// then left as they are
+	Since  time.Time
+	Pages  int  // listing pages visited per source at most, maxPages by default
+	DryRun bool // parse and dedupe the posts, but write nothing
+}
 
-	c.OnRequest(func(r *colly.Request) {
-		r.Headers.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36")
-		log.Println("Visiting", r.URL)
-	})
+// Report is the outcome of a scrape
+type Report struct {
+	Posts   []model.Post       // new, inserted unless a dry run
+	Failed  []model.DataSource // unhealthy, or with pages that could not be fetched or parsed
+	Skipped []model.DataSource // being scrapped by another run
+}
 
-	c.OnResponse(func(r *colly.Response) {
-		log.Println("Response code", r.StatusCode)
-	})
+// Scrap collects the posts published since the watermark of each source, following the next page links
+// up to maxPages, and inserts the posts not stored yet. Only the sources named are scrapped, if any
+func Scrap(ctx context.Context, names ...model.DataSource) ([]model.Post, error) {
+	report, err := Run(ctx, Options{Sources: names})
+	return report.Posts, err
+}
 
-	c.OnError(func(r *colly.Response, err error) {
-		log.Println("Error", err.Error())
-	})
+// Run scrapes the sources as the options say. Pages are no longer fetched once ctx is done
+func Run(ctx context.Context, opts Options) (Report, error) {
+	report := Report{Posts: []model.Post{}, Failed: []model.DataSource{}, Skipped: []model.DataSource{}}
+	if err := LoadSpecs(ctx); err != nil {
+		return report, err
+	}
+	if opts.Pages <= 0 {
+		opts.Pages = maxPages
+	}
+	backfill := !opts.Since.IsZero()
 
-	posts := []model.Post{}
+	all := sources()
+	for _, name := range opts.Sources {
+		if !slices.ContainsFunc(all, func(s source) bool { return s.name == name }) {
+			return report, errors.New("Unknown source " + string(name))
+		}
+	}
+	if len(opts.Sources) > 0 {
+		all = slices.DeleteFunc(all, func(s source) bool { return !slices.Contains(opts.Sources, s.name) })
+	}
+	// a dry run writes nothing, leases included
+	if !opts.DryRun {
+		var leases []*lock.Lease
+		all, report.Skipped, leases = lockSources(ctx, all)
+		defer release(leases)
+		// once a lease is lost, another run may scrape its source, so the run stops before inserting posts or
+		// moving watermarks twice
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		for _, lease := range leases {
+			context.AfterFunc(lease.Context(), func(lease *lock.Lease) func() {
+				return func() { cancel(context.Cause(lease.Context())) }
+			}(lease))
+		}
+	}
 
-	c.OnHTML("article.item", func(h *colly.HTMLElement) {
-		locations := []string{}
-		airlines := []string{}
+	// read once the sources are locked, as another run may have moved them on
+	watermarks, err := getWatermarks(ctx)
+	if err != nil {
+		return report, errors.New("Cannot get watermarks: " + err.Error())
+	}
+	startedAt := time.Now().UTC()
+	ch := make(chan result)
+	for _, s := range all {
+		go func(s source) {
+			since := watermarks[s.name]
+			if backfill {
+				since = opts.Since
+			}
+			ch <- crawl(ctx, s, since, opts.Pages, !backfill && !opts.DryRun)
+		}(s)
+	}
 
-		div := h.DOM
-		title := div.Find(".penci-entry-title > a").Text()
-		locations = append(locations, extractDestinations(title)...)
-		airlines = append(airlines, extractAirlines(title)...)
+	posts := []model.Post{}
+	healthy := []model.DataSource{}
+	for range all {
+		scrappedPosts := <-ch
+		if scrappedPosts.error != nil {
+			log.Println("Cannot parse posts of", scrappedPosts.source, scrappedPosts.error.Error())
+		}
+		posts = append(posts, scrappedPosts.posts...)
+		if scrappedPosts.healthy {
+			healthy = append(healthy, scrappedPosts.source)
+		}
+		if !scrappedPosts.healthy || scrappedPosts.error != nil {
+			report.Failed = append(report.Failed, scrappedPosts.source)
+		}
+	}
+	slices.Sort(report.Failed)
+	if cause := context.Cause(ctx); errors.Is(cause, lock.ErrLost) {
+		return report, errors.New("Cannot scrape: " + cause.Error())
+	}
 
-		URL := div.Find(".penci-entry-title > a").AttrOr("href", "https://flyday.hk/")
-		dateStr := div.Find("time.published").AttrOr("datetime", "https://flyday.hk/")
+	posts, err = newPosts(ctx, posts)
+	if err != nil {
+		return report, err
+	}
+	if opts.DryRun {
+		report.Posts = posts
+		return report, nil
+	}
+	if report.Posts, err = insert(ctx, posts); err != nil {
+		return report, err
+	}
 
-		pubDate, err := time.Parse(time.RFC3339, dateStr)
+	if !backfill {
+		// the watermark of an unhealthy source is kept, so that its posts are collected once it is fixed
+		err = repository.System.SetWatermarks(ctx, healthy, startedAt)
 		if err != nil {
-			log.Println("Cannot parse dateStr", pubDate)
-			ch <- result{nil, err}
-			return
-		}
-		if pubDate.Before(lastScrapDate) {
-			return
+			return report, errors.New("Cannot update system info: " + err.Error())
 		}
+	}
 
-		summary := div.Find(".item-content > p").Text()
-		airlines = append(airlines, extractAirlines(summary)...)
-
-		div.Find(".cat > a").Each(func(_ int, s *goquery.Selection) {
-			category := s.Text()
-			locations = append(locations, extractDestinations(category)...)
-		})
-
-		locations = collection.RemoveListDuplicates[string](locations)
-		airlines = collection.RemoveListDuplicates[string](airlines)
-
-		posts = append(posts, model.Post{
-			Title:     title,
-			Summary:   summary,
-			Locations: locations,
-			Airlines:  airlines,
-			URL:       URL,
-			PubDate:   pubDate,
-			CreatedAt: time.Now().UTC(),
-			Source:    model.DataSourceFlyday,
-		})
-	})
+	return report, nil
+}
 
-	c.Visit(flydayURL)
+// lockSources takes the lease of each source, so that overlapping runs neither insert nor notify of the
+// same posts twice. Sources leased by another run are skipped
+func lockSources(ctx context.Context, all []source) ([]source, []model.DataSource, []*lock.Lease) {
+	locked := []source{}
+	skipped := []model.DataSource{}
+	leases := []*lock.Lease{}
+	for _, s := range all {
+		lease, err := lock.Acquire(ctx, "scrape:"+string(s.name))
+		if errors.Is(err, lock.ErrHeld) {
+			log.Println("Skipping", s.name, "as another run is scrapping it:", err.Error())
+			skipped = append(skipped, s.name)
+			continue
+		}
+		if err != nil {
+			log.Println("Skipping", s.name, "as it cannot be locked:", err.Error())
+			continue
+		}
+		locked = append(locked, s)
+		leases = append(leases, lease)
+	}
+	return locked, skipped, leases
+}
 
-	ch <- result{posts, nil}
+func release(leases []*lock.Lease) {
+	for _, lease := range leases {
+		lease.Release()
+	}
 }
 
-func scrapFlyAgain(ch chan result, lastScrapDate time.Time) {
-	log.Println("Start scrapping flyAgain")
-	c := colly.NewCollector(
-		colly.AllowedDomains("flyagain.la"),
-	)
+// crawl visits the listing pages, or the feed, of the source from the first one and parses the posts
+// published since, until a page lists an older post, has no next page link or pages have been visited.
+// With record, the first page is checked by pkg/health, so that markup changes are told apart from quiet
+// days
+func crawl(ctx context.Context, s source, since time.Time, pages int, record bool) result {
+	log.Println("Start scrapping", s.name)
+	run := model.SourceRun{Source: s.name, At: time.Now().UTC()}
+	posts := []model.Post{}
+	visited := 0
+	var err error
+
+	c := newCollector(ctx, func(r *colly.Response, fetchErr error) {
+		log.Println("Error", fetchErr.Error())
+		if visited == 0 {
+			run.StatusCode, run.FetchError = r.StatusCode, fetchErr.Error()
+		} else {
+			err = errors.New("Cannot fetch " + r.Request.URL.String() + ": " + fetchErr.Error())
+		}
+	}, colly.AllowedDomains(s.domain))
 
 	c.OnRequest(func(r *colly.Request) {
-		r.Headers.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36")
 		log.Println("Visiting", r.URL)
 	})
 
-	c.OnResponse(func(r *colly.Response) {
-		log.Println("Response code", r.StatusCode)
-	})
-
-	c.OnError(func(r *colly.Response, err error) {
-		log.Println("Error", err.Error())
-	})
-
-	posts := []model.Post{}
-
-	c.OnHTML("div.blogpostcategory", func(h *colly.HTMLElement) {
-		locations := []string{}
-		airlines := []string{}
-
-		div := h.DOM
-		title := div.Find("h2.title > a").Text()
-		locations = append(locations, extractDestinations(title)...)
-		airlines = append(airlines, extractAirlines(title)...)
-
-		URL := div.AttrOr("this_url", "https://flyagain.la/")
-		dateStr := strings.TrimSpace(div.Find("a.post-meta-time").Text())
-
-		pubDate, err := time.Parse("January 2, 2006", dateStr)
-		if err != nil {
-			log.Println("Cannot parse dateStr", pubDate)
-			ch <- result{nil, err}
-			return
+	handle := func(result parsed, r *colly.Request) {
+		if visited == 0 {
+			run.Elements = result.elements
+			run.Failures = len(result.failures)
 		}
-
-		if pubDate.Before(lastScrapDate) {
-			return
+		visited++
+		if len(result.failures) > 0 {
+			err = errors.Wrap(result.failures[0], 0)
 		}
 
-		summary := ""
-		div.Find("div.blogcontent > p").Each(func(_ int, s *goquery.Selection) {
-			fieldName := s.Find("span").Text()
-			if strings.Contains(fieldName, "航點") {
-				locations = append(locations, extractDestinations(s.Text())...)
-			} else if strings.Contains(fieldName, "航空公司") {
-				airlines = append(airlines, extractAirlines(s.Text())...)
+		reached := len(result.posts) == 0
+		for _, post := range result.posts {
+			if post.PubDate.Before(since) {
+				reached = true
+				continue
 			}
-			if strings.Contains(fieldName, "結論") {
-				summary = strings.TrimSpace(s.Text())
-				airlines = append(airlines, extractAirlines(summary)...)
-			}
-		})
-
-		locations = append(locations, extractDestinations(div.Find("div.post-meta > div > a").Text())...)
-		locations = collection.RemoveListDuplicates[string](locations)
-		airlines = collection.RemoveListDuplicates[string](airlines)
-
-		posts = append(posts, model.Post{
-			Title:     title,
-			Summary:   summary,
-			Locations: locations,
-			Airlines:  airlines,
-			URL:       URL,
-			PubDate:   pubDate,
-			CreatedAt: time.Now().UTC(),
-			Source:    model.DataSourceFlyAgain,
-		})
-	})
-
-	c.Visit(flyAgainURL)
+			post.CreatedAt = time.Now().UTC()
+			posts = append(posts, post)
+		}
 
-	ch <- result{posts, nil}
-}
+		if !reached && result.next != "" && visited < pages {
+			// fetch errors are handled by newCollector, even when retries succeed, so only the pages
+			// never fetched are told here, e.g. disallowed by robots.txt
+			before, errBefore := visited, err
+			if visitErr := r.Visit(result.next); visitErr != nil && visited == before && err == errBefore {
+				err = errors.New("Cannot visit " + result.next + ": " + visitErr.Error())
+			}
+		}
+	}
 
-func extractDestinations(s string) []string {
-	destMap := map[string]struct{}{}
+	c.OnResponse(func(r *colly.Response) {
+		log.Println("Response code", r.StatusCode)
+		if visited == 0 {
+			run.StatusCode = r.StatusCode
+		}
+		if s.feed {
+			handle(parseFeed(s.name, r.Body), r.Request)
+		}
+	})
 
-	// directly check if the string contains the destination
-	for _, destItem := range tags.Destinations {
-		if strings.Contains(s, destItem[languages.TC]) {
-			destMap[destItem[languages.TC]] = struct{}{}
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		if !s.feed {
+			handle(parseSpec(s.spec, h.DOM), h.Request)
 		}
+	})
+
+	if visitErr := c.Visit(s.url); visitErr != nil && visited == 0 && run.FetchError == "" {
+		run.FetchError = visitErr.Error()
 	}
 
-	// check if the string contains the destination alias and convert to the destination
-	for key := range tags.AliasToDestMap {
-		if strings.Contains(s, key) {
-			for _, destName := range tags.AliasToDestMap[key] {
-				destMap[destName] = struct{}{}
-			}
+	run.Pages = visited
+	run.NewPosts = len(posts)
+	healthy := true
+	if record {
+		status, healthErr := health.Record(ctx, run)
+		if healthErr != nil {
+			log.Println("Cannot record source health:", healthErr.Error())
+			healthy = len(health.Check(run, nil)) == 0
+		} else {
+			healthy = status.Healthy
+		}
+		if !healthy {
+			log.Println("Source", s.name, "is unhealthy:", status.Problems)
 		}
 	}
 
-	output := make([]string, 0, len(destMap))
-	for key := range destMap {
-		output = append(output, key)
-	}
-	return output
+	return result{source: s.name, posts: posts, healthy: healthy, error: err}
 }
 
-func extractAirlines(s string) []string {
-	airlineMap := map[string]struct{}{}
+// newPosts returns the posts whose URL is not stored yet, as later pages and backfills overlap the posts
+// collected before. The article pages of the new posts are fetched when enabled
+func newPosts(ctx context.Context, posts []model.Post) ([]model.Post, error) {
+	if len(posts) == 0 {
+		return posts, nil
+	}
 
-	// directly check if the string contains the airline
-	for _, airlineItem := range tags.Airlines {
-		if strings.Contains(s, airlineItem[languages.TC]) {
-			airlineMap[airlineItem[languages.TC]] = struct{}{}
-		}
+	urls := collection.Map(posts, func(p model.Post) string {
+		return p.URL
+	})
+	// posts archived past their retention are stored too, e.g. when backfilling
+	seen, err := repository.Posts.StoredURLs(ctx, urls)
+	if err != nil {
+		return nil, err
 	}
 
-	// check if the string contains the airline alias and convert to the airline
-	for key := range tags.AliasToAirlineMap {
-		if strings.Contains(s, key) {
-			for _, airlineName := range tags.AliasToAirlineMap[key] {
-				airlineMap[airlineName] = struct{}{}
-			}
+	output := []model.Post{}
+	for _, post := range posts {
+		if seen[post.URL] {
+			continue
 		}
+		seen[post.URL] = true
+		output = append(output, post)
 	}
-
-	output := make([]string, 0, len(airlineMap))
-	for key := range airlineMap {
-		output = append(output, key)
+	if len(output) > 0 && config.Cfg.Scrapper.FetchArticles {
+		output = fetchArticles(ctx, output)
 	}
-	return output
-
+	return output, nil
 }
 
-func updateLastScrapDate() error {
-	update := bson.D{{
-		Key:   "$set",
-		Value: bson.D{{Key: "last_updated", Value: time.Now().UTC()}},
-	}}
-	opts := options.Update().SetUpsert(true)
-	_, err := mongoDB.UpdateById("system", "scrapper", update, opts)
-	return err
+func insert(ctx context.Context, posts []model.Post) ([]model.Post, error) {
+	if err := repository.Posts.Insert(ctx, posts); err != nil {
+		return nil, err
+	}
+	if len(posts) > 0 {
+		fmt.Printf("Logged %v new posts\n", len(posts))
+	}
+	return posts, nil
 }
 
-func getLastScrapDate() (time.Time, error) {
-	type lu = struct {
-		LastUpdated time.Time `bson:"last_updated"`
+// getWatermarks returns the watermark of each source. Sources never scrapped start from the zero time,
+// i.e. crawl maxPages pages
+func getWatermarks(ctx context.Context) (map[model.DataSource]time.Time, error) {
+	output, err := repository.System.Watermarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	since := map[model.DataSource]time.Time{}
+	for _, s := range sources() {
+		if t, ok := output.Sources[string(s.name)]; ok {
+			since[s.name] = t
+		} else {
+			since[s.name] = output.LastUpdated
+		}
 	}
-	output, err := mongoDB.GetById[lu]("system", "scrapper")
-	return output.LastUpdated, err
+	return since, nil
 }
@@ -0,0 +1,390 @@
+package scrapper
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/go-errors/errors"
+	colly "github.com/gocolly/colly/v2"
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/repository"
+	"github.com/jeffyfung/flight-info-agg/pkg/tags"
+)
+
+// the specs of the sources shipped with the code. Specs stored in the database take precedence by name
+//
+//go:embed specs/*.json
+var specFiles embed.FS
+
+var (
+	specsMu sync.RWMutex
+	specs   = mustDefaultSpecs()
+)
+
+// fields tags can be extracted from on listings
+var specTagFields = []string{
+	model.FieldTitle,
+	model.FieldSummary,
+	model.FieldCategory,
+	model.FieldDestinations,
+	model.FieldAirlines,
+}
+
+// DefaultSpecs returns the specs shipped with the code
+func DefaultSpecs() ([]model.SourceSpec, error) {
+	entries, err := specFiles.ReadDir("specs")
+	if err != nil {
+		return nil, errors.New("Cannot list source specs: " + err.Error())
+	}
+	output := []model.SourceSpec{}
+	for _, entry := range entries {
+		b, err := specFiles.ReadFile(path.Join("specs", entry.Name()))
+		if err != nil {
+			return nil, errors.New("Cannot read source spec: " + err.Error())
+		}
+		spec, err := ParseSpec(b)
+		if err != nil {
+			return nil, errors.New("Cannot parse source spec " + entry.Name() + ": " + err.Error())
+		}
+		output = append(output, spec)
+	}
+	return output, nil
+}
+
+func mustDefaultSpecs() []model.SourceSpec {
+	output, err := DefaultSpecs()
+	if err != nil {
+		panic(err)
+	}
+	return output
+}
+
+// ParseSpec reads a spec from JSON
+func ParseSpec(b []byte) (model.SourceSpec, error) {
+	var spec model.SourceSpec
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&spec); err != nil {
+		return spec, errors.New(err)
+	}
+	return spec, nil
+}
+
+// LoadSpecs reads the specs stored in the database over the default ones
+func LoadSpecs(ctx context.Context) error {
+	stored, err := repository.Specs.List(ctx)
+	if err != nil {
+		return err
+	}
+	defaults, err := DefaultSpecs()
+	if err != nil {
+		return err
+	}
+
+	byName := map[model.DataSource]model.SourceSpec{}
+	for _, spec := range defaults {
+		byName[spec.Name] = spec
+	}
+	for _, spec := range stored {
+		if problems := ValidateSpec(spec); len(problems) > 0 {
+			return errors.New("Invalid source spec " + string(spec.Name) + ": " + strings.Join(problems, ", "))
+		}
+		byName[spec.Name] = spec
+	}
+
+	output := []model.SourceSpec{}
+	for _, spec := range byName {
+		output = append(output, spec)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Name < output[j].Name
+	})
+
+	specsMu.Lock()
+	defer specsMu.Unlock()
+	specs = output
+	return nil
+}
+
+// SaveSpec stores a valid spec, to be run from the next scrape
+func SaveSpec(ctx context.Context, spec model.SourceSpec) error {
+	if problems := ValidateSpec(spec); len(problems) > 0 {
+		return errors.New("Invalid source spec: " + strings.Join(problems, ", "))
+	}
+	now := time.Now().UTC()
+	spec.UpdatedAt = &now
+	return repository.Specs.Save(ctx, spec)
+}
+
+func currentSpecs() []model.SourceSpec {
+	specsMu.RLock()
+	defer specsMu.RUnlock()
+	return specs
+}
+
+func specOf(name model.DataSource) (model.SourceSpec, bool) {
+	for _, spec := range currentSpecs() {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return model.SourceSpec{}, false
+}
+
+// ValidateSpec returns what is wrong with the spec, without fetching anything
+func ValidateSpec(spec model.SourceSpec) []string {
+	problems := []string{}
+	if spec.Name == "" {
+		problems = append(problems, "name is missing")
+	}
+	if u, err := url.Parse(spec.URL); err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		problems = append(problems, "url is not an http(s) URL")
+	}
+
+	selectors := map[string]string{"item": spec.Item, "title": spec.Title.CSS, "link": spec.Link.CSS, "date": spec.Date.CSS}
+	if spec.Summary != nil {
+		selectors["summary"] = spec.Summary.CSS
+	}
+	if spec.Categories != nil {
+		selectors["categories"] = spec.Categories.CSS
+	}
+	if spec.Next != nil {
+		selectors["next"] = spec.Next.CSS
+	}
+	if spec.Labelled != nil {
+		selectors["labelled paragraphs"], selectors["labelled label"] = spec.Labelled.Paragraphs, spec.Labelled.Label
+		for _, f := range spec.Labelled.Fields {
+			if f.Label == "" || !slices.Contains([]string{model.FieldDestinations, model.FieldAirlines, model.FieldSummary}, f.Field) {
+				problems = append(problems, "labelled field "+f.Label+" must have a label and be destinations, airlines or summary")
+			}
+		}
+	}
+	if spec.Article != nil {
+		selectors["article body"] = spec.Article.Body
+		if spec.Article.Author != nil {
+			selectors["article author"] = spec.Article.Author.CSS
+		}
+		if spec.Article.Image != nil {
+			selectors["article image"] = spec.Article.Image.CSS
+		}
+		for _, f := range spec.Article.Fields {
+			if f.Label == "" || !slices.Contains([]string{model.FieldDestinations, model.FieldAirlines}, f.Field) {
+				problems = append(problems, "article field "+f.Label+" must have a label and be destinations or airlines")
+			}
+		}
+	}
+	names := []string{}
+	for name := range selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		css := selectors[name]
+		if css == "" {
+			// the post itself, which the post selector and page-wide selectors cannot be
+			if name == "item" || name == "next" || strings.HasPrefix(name, "labelled") || strings.HasPrefix(name, "article") {
+				problems = append(problems, name+" selector is missing")
+			}
+			continue
+		}
+		if _, err := cascadia.Compile(css); err != nil {
+			problems = append(problems, name+" selector is invalid: "+err.Error())
+		}
+	}
+	if spec.Link.CSS == "" && spec.Link.Attr == "" {
+		problems = append(problems, "link must be an attribute when it selects the post itself")
+	}
+
+	if spec.DateLayout == "" {
+		problems = append(problems, "date_layout is missing")
+	}
+	if spec.Timezone != "" {
+		if _, err := time.LoadLocation(spec.Timezone); err != nil {
+			problems = append(problems, "timezone is unknown: "+err.Error())
+		}
+	}
+	for _, field := range spec.TagFields {
+		if !slices.Contains(specTagFields, field) {
+			problems = append(problems, "tag field "+field+" is unknown")
+		}
+	}
+	return problems
+}
+
+// parseSpec is the parser of every HTML source, run as its spec says
+func parseSpec(spec model.SourceSpec, page *goquery.Selection) parsed {
+	result := parsed{posts: []model.Post{}}
+	loc := time.UTC
+	if spec.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(spec.Timezone); err != nil {
+			result.failures = append(result.failures, errors.New("Cannot load timezone "+spec.Timezone+": "+err.Error()))
+			return result
+		}
+	}
+	tagged := func(field string) bool {
+		return len(spec.TagFields) == 0 || slices.Contains(spec.TagFields, field)
+	}
+	extract := func(matches []model.TagMatch, field string, s string) []model.TagMatch {
+		if !tagged(field) {
+			return matches
+		}
+		return append(matches, tags.Extract(field, s)...)
+	}
+
+	page.Find(spec.Item).Each(func(_ int, item *goquery.Selection) {
+		result.elements++
+		matches := []model.TagMatch{}
+
+		title := valueOf(item, spec.Title)
+		matches = extract(matches, model.FieldTitle, title)
+
+		URL := resolve(spec.URL, valueOf(item, spec.Link))
+		if URL == "" {
+			result.failures = append(result.failures, errors.New("No "+string(spec.Name)+" link for "+title))
+			return
+		}
+
+		dateStr := valueOf(item, spec.Date)
+		pubDate, parseErr := time.ParseInLocation(spec.DateLayout, dateStr, loc)
+		if parseErr != nil {
+			result.failures = append(result.failures, errors.New("Cannot parse "+string(spec.Name)+" date "+dateStr+": "+parseErr.Error()))
+			return
+		}
+
+		summary := ""
+		if spec.Summary != nil {
+			summary = valueOf(item, *spec.Summary)
+			matches = extract(matches, model.FieldSummary, summary)
+		}
+
+		if spec.Labelled != nil {
+			item.Find(spec.Labelled.Paragraphs).Each(func(_ int, p *goquery.Selection) {
+				label := p.Find(spec.Labelled.Label).Text()
+				for _, f := range spec.Labelled.Fields {
+					if !strings.Contains(label, f.Label) {
+						continue
+					}
+					value := p.Text()
+					if f.Field == model.FieldSummary {
+						value = strings.TrimSpace(value)
+						summary = value
+					}
+					matches = extract(matches, f.Field, value)
+				}
+			})
+		}
+
+		if spec.Categories != nil {
+			selectionOf(item, spec.Categories.CSS).Each(func(_ int, s *goquery.Selection) {
+				matches = extract(matches, model.FieldCategory, s.Text())
+			})
+		}
+
+		result.posts = append(result.posts, newPost(spec.Name, title, summary, URL, pubDate, matches))
+	})
+
+	if spec.Next != nil {
+		result.next = resolve(spec.URL, valueOf(page, *spec.Next))
+	}
+	return result
+}
+
+func selectionOf(s *goquery.Selection, css string) *goquery.Selection {
+	if css == "" {
+		return s
+	}
+	return s.Find(css)
+}
+
+func valueOf(s *goquery.Selection, sel model.Selector) string {
+	selection := selectionOf(s, sel.CSS)
+	if sel.Attr != "" {
+		return strings.TrimSpace(selection.First().AttrOr(sel.Attr, ""))
+	}
+	return strings.TrimSpace(selection.Text())
+}
+
+// resolve makes links relative to the listing absolute
+func resolve(base string, link string) string {
+	if link == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return link
+	}
+	l, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return b.ResolveReference(l).String()
+}
+
+// SpecCheck is what a spec gives on a page
+type SpecCheck struct {
+	Problems []string
+	Elements int
+	Posts    []model.Post
+	Next     string
+}
+
+// CheckSpec validates the spec and runs it over the page, telling posts without a summary or tags apart
+func CheckSpec(spec model.SourceSpec, page []byte) (SpecCheck, error) {
+	check := SpecCheck{Problems: ValidateSpec(spec)}
+	if len(check.Problems) > 0 {
+		return check, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return check, errors.New("Cannot read page: " + err.Error())
+	}
+
+	result := parseSpec(spec, doc.Selection)
+	check.Elements, check.Posts, check.Next = result.elements, result.posts, result.next
+	if result.elements == 0 {
+		check.Problems = append(check.Problems, "no post matched the item selector")
+	}
+	for _, failure := range result.failures {
+		check.Problems = append(check.Problems, failure.Error())
+	}
+	for _, post := range result.posts {
+		if post.Title == "" {
+			check.Problems = append(check.Problems, "post "+post.URL+" has no title")
+		}
+	}
+	if spec.Next != nil && check.Next == "" {
+		check.Problems = append(check.Problems, "no next page link matched")
+	}
+	return check, nil
+}
+
+// FetchPage fetches a page as scrapes do, e.g. to check a spec against the live site
+func FetchPage(ctx context.Context, pageURL string) ([]byte, error) {
+	var body []byte
+	var fetchErr error
+	c := newCollector(ctx, func(r *colly.Response, err error) {
+		fetchErr = err
+	})
+	c.OnResponse(func(r *colly.Response) {
+		body = r.Body
+	})
+	if err := c.Visit(pageURL); err != nil && body == nil && fetchErr == nil {
+		fetchErr = err
+	}
+	if fetchErr != nil {
+		return nil, errors.New("Cannot fetch " + pageURL + ": " + fetchErr.Error())
+	}
+	return body, nil
+}
@@ -0,0 +1,173 @@
+package tags
+
+import (
+	"unicode/utf8"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+)
+
+const defaultRuleWindow = 2
+
+type (
+	// pattern is a text to look for and the tags it resolves to
+	pattern struct {
+		text  []rune // folded, see languages.Fold
+		exact string // when set, the text must be written exactly so
+		kind  model.TagKind
+		tags  []string
+		alias bool
+		latin bool // latin patterns such as codes must be whole words, so that e.g. CX is not found in "CXL"
+		rule  *model.ContextRule
+	}
+
+	// automaton is an Aho-Corasick automaton over every pattern of the dictionaries, so that a text is
+	// scanned once however large the dictionaries grow
+	automaton struct {
+		patterns []pattern
+		next     []map[rune]int32
+		fail     []int32
+		out      [][]int32 // patterns ending at each state, including those reached through fail links
+	}
+
+	// hit is an occurrence of a pattern over runes [start, end) of the text
+	hit struct {
+		start   int
+		end     int
+		pattern int32
+	}
+)
+
+func newAutomaton(patterns []pattern) *automaton {
+	a := &automaton{
+		patterns: patterns,
+		next:     []map[rune]int32{{}},
+		fail:     []int32{0},
+		out:      [][]int32{nil},
+	}
+
+	for i, p := range patterns {
+		state := int32(0)
+		for _, r := range p.text {
+			next, ok := a.next[state][r]
+			if !ok {
+				next = int32(len(a.next))
+				a.next = append(a.next, map[rune]int32{})
+				a.fail = append(a.fail, 0)
+				a.out = append(a.out, nil)
+				a.next[state][r] = next
+			}
+			state = next
+		}
+		a.out[state] = append(a.out[state], int32(i))
+	}
+
+	// breadth-first so that the fail state of a state is complete before the state itself
+	queue := []int32{}
+	for _, child := range a.next[0] {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for r, child := range a.next[state] {
+			fail := a.fail[state]
+			for fail > 0 && !has(a.next[fail], r) {
+				fail = a.fail[fail]
+			}
+			if next, ok := a.next[fail][r]; ok && next != child {
+				a.fail[child] = next
+			}
+			a.out[child] = append(a.out[child], a.out[a.fail[child]]...)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// scan returns every occurrence of every pattern in text, ordered by end
+func (a *automaton) scan(text []rune) []hit {
+	hits := []hit{}
+	state := int32(0)
+	for i, r := range text {
+		for state > 0 && !has(a.next[state], r) {
+			state = a.fail[state]
+		}
+		state = a.next[state][r] // stays at the root when r starts no pattern
+		for _, p := range a.out[state] {
+			hits = append(hits, hit{start: i + 1 - len(a.patterns[p].text), end: i + 1, pattern: p})
+		}
+	}
+	return hits
+}
+
+func has(next map[rune]int32, r rune) bool {
+	_, ok := next[r]
+	return ok
+}
+
+// accepts tells whether the hit over the folded text stands as a match in the original text, according to
+// the word boundaries, the case and the context rule of its pattern
+func (a *automaton) accepts(original []rune, text []rune, h hit) bool {
+	p := a.patterns[h.pattern]
+	if p.latin && (h.start > 0 && isWordRune(text[h.start-1]) || h.end < len(text) && isWordRune(text[h.end])) {
+		return false
+	}
+	if p.exact != "" && string(original[h.start:h.end]) != p.exact {
+		return false
+	}
+	if p.rule == nil {
+		return true
+	}
+
+	window := p.rule.Window
+	if window <= 0 {
+		window = defaultRuleWindow
+	}
+	for _, word := range p.rule.FollowedBy {
+		w := []rune(languages.Fold(word))
+		for start := h.end; start <= h.end+window && start+len(w) <= len(text); start++ {
+			if string(text[start:start+len(w)]) == string(w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// leftmostLongest keeps, among overlapping hits, the one starting first and then the longest, e.g. 東南亞
+// rather than 南亞 and 澳洲航空 rather than 澳洲. Hits over the same span are all kept, as the same text can
+// be both a place and an airline
+func leftmostLongest(hits []hit) []hit {
+	sorted := make([]hit, len(hits))
+	copy(sorted, hits)
+	sortHits(sorted)
+
+	output := []hit{}
+	end := 0
+	for _, h := range sorted {
+		if n := len(output); n > 0 && output[n-1].start == h.start && output[n-1].end == h.end {
+			output = append(output, h)
+			continue
+		}
+		if h.start < end {
+			continue
+		}
+		output = append(output, h)
+		end = h.end
+	}
+	return output
+}
+
+func isLatin(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
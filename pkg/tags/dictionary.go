@@ -0,0 +1,162 @@
+package tags
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"sync/atomic"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+)
+
+// dictionary is an immutable, indexed snapshot of the taxonomy, the airlines and the multi-destination
+// aliases. It is swapped as a whole when the dictionaries are reloaded
+type dictionary struct {
+	version       int64
+	places        []*placeNode // depth-first order, siblings sorted by their English name
+	placeIndex    map[string]*placeNode
+	placeLookup   map[string]string // lower-cased code, name or alias to code
+	iataPlaces    map[string]bool   // codes that can be recognised in text
+	destAliases   map[string][]string
+	airlines      []Airline
+	airlineIndex  map[string]Airline
+	airlineLookup map[string]string // folded code, name or alias of an airline or a group to code
+	airlineGroups []*airlineGroup
+	groupIndex    map[string]*airlineGroup
+	automaton     *automaton
+}
+
+var (
+	current      atomic.Pointer[dictionary]
+	iataCodeLike = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+func init() {
+	SetDictionary(0, Taxonomy, Airlines, AliasToDestMap)
+}
+
+func dict() *dictionary {
+	return current.Load()
+}
+
+// Version of the dictionaries in use. The Go tables are version 0
+func Version() int64 {
+	return dict().version
+}
+
+// SetDictionary replaces the dictionaries used for extraction and labels, e.g. once they are loaded
+// from the database
+func SetDictionary(version int64, taxonomy []Place, airlines []Airline, destAliases map[string][]string) {
+	d := &dictionary{
+		version:       version,
+		places:        []*placeNode{},
+		placeIndex:    map[string]*placeNode{},
+		placeLookup:   map[string]string{},
+		iataPlaces:    map[string]bool{},
+		destAliases:   destAliases,
+		airlines:      slices.Clone(airlines),
+		airlineIndex:  map[string]Airline{},
+		airlineLookup: map[string]string{},
+		groupIndex:    map[string]*airlineGroup{},
+	}
+	patterns := []pattern{}
+
+	var walk func(places []Place, parent string, depth int)
+	walk = func(places []Place, parent string, depth int) {
+		sorted := slices.Clone(places)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Names[languages.EN] < sorted[j].Names[languages.EN]
+		})
+		for _, place := range sorted {
+			node := &placeNode{
+				code:    place.Code,
+				names:   place.Names,
+				aliases: place.Aliases,
+				level:   levels[min(depth, len(levels)-1)],
+				parent:  parent,
+			}
+			d.places = append(d.places, node)
+			d.placeIndex[node.code] = node
+			if parent != "" {
+				d.placeIndex[parent].children = append(d.placeIndex[parent].children, node.code)
+			}
+
+			for _, key := range append([]string{node.code, node.names[languages.TC], node.names[languages.SC], node.names[languages.EN]}, node.aliases...) {
+				if key != "" {
+					d.placeLookup[languages.Fold(key)] = node.code
+				}
+			}
+			if (node.level == LevelCity || node.level == LevelAirport) && iataCodeLike.MatchString(node.code) {
+				d.iataPlaces[node.code] = true
+			}
+
+			// a place is recognised by its names, its aliases or its IATA code
+			for _, lang := range []languages.Lang{languages.TC, languages.SC, languages.EN} {
+				patterns = appendPattern(patterns, model.TagKindLocation, node.names[lang], []string{node.code}, false, nil)
+			}
+			if d.iataPlaces[node.code] {
+				patterns = appendCode(patterns, model.TagKindLocation, node.code)
+			}
+			for _, alias := range node.aliases {
+				patterns = appendPattern(patterns, model.TagKindLocation, alias, []string{node.code}, true, place.Rules)
+			}
+
+			walk(place.Children, node.code, depth+1)
+		}
+	}
+	walk(taxonomy, "", 0)
+
+	for _, airline := range d.airlines {
+		d.airlineIndex[airline.Code] = airline
+		for _, key := range append([]string{airline.Code, airline.Names[languages.TC], airline.Names[languages.SC], airline.Names[languages.EN]}, airline.Aliases...) {
+			if key != "" {
+				d.airlineLookup[languages.Fold(key)] = airline.Code
+			}
+		}
+
+		for _, lang := range []languages.Lang{languages.TC, languages.SC, languages.EN} {
+			patterns = appendPattern(patterns, model.TagKindAirline, airline.Names[lang], []string{airline.Code}, false, nil)
+		}
+		if !airline.AmbiguousCode {
+			patterns = appendCode(patterns, model.TagKindAirline, airline.Code)
+		}
+		for _, alias := range airline.Aliases {
+			patterns = appendPattern(patterns, model.TagKindAirline, alias, []string{airline.Code}, true, airline.Rules)
+		}
+	}
+
+	d.groupAirlines()
+
+	aliases := make([]string, 0, len(destAliases))
+	for alias := range destAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		patterns = appendPattern(patterns, model.TagKindLocation, alias, destAliases[alias], true, nil)
+	}
+	d.automaton = newAutomaton(patterns)
+
+	current.Store(d)
+}
+
+// appends the pattern for a name or an alias, with the context rule given for it if any. Names and aliases
+// match whatever their case or script
+func appendPattern(patterns []pattern, kind model.TagKind, text string, tags []string, alias bool, rules []model.ContextRule) []pattern {
+	if text == "" {
+		return patterns
+	}
+	p := pattern{text: []rune(languages.Fold(text)), kind: kind, tags: tags, alias: alias, latin: isLatin(text)}
+	for i := range rules {
+		if rules[i].Alias == text {
+			p.rule = &rules[i]
+		}
+	}
+	return append(patterns, p)
+}
+
+// codes must be written in upper case, so that e.g. "sea" is not taken for Seattle
+func appendCode(patterns []pattern, kind model.TagKind, code string) []pattern {
+	return append(patterns, pattern{text: []rune(languages.Fold(code)), exact: code, kind: kind, tags: []string{code}, latin: true})
+}
@@ -0,0 +1,115 @@
+package tags
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+)
+
+// kinds of tags extracted from each field of a post, e.g. summaries mention too many places in passing
+// for their destinations to be reliable
+var fieldKinds = map[string][]model.TagKind{
+	model.FieldTitle:        {model.TagKindLocation, model.TagKindAirline},
+	model.FieldSummary:      {model.TagKindAirline},
+	model.FieldCategory:     {model.TagKindLocation},
+	model.FieldDestinations: {model.TagKindLocation},
+	model.FieldAirlines:     {model.TagKindAirline},
+	model.FieldBody:         {model.TagKindAirline},
+}
+
+// Extract finds the tags mentioned in a field of a post
+func Extract(field string, s string) []model.TagMatch {
+	return extract(field, s, fieldKinds[field])
+}
+
+// ExtractDestinations finds destinations mentioned in s, keeping the matched text as evidence
+func ExtractDestinations(field string, s string) []model.TagMatch {
+	return extract(field, s, []model.TagKind{model.TagKindLocation})
+}
+
+// ExtractAirlines finds airlines mentioned in s, keeping the matched text as evidence
+func ExtractAirlines(field string, s string) []model.TagMatch {
+	return extract(field, s, []model.TagKind{model.TagKindAirline})
+}
+
+// extract scans s once for the names, aliases and codes of the dictionaries, in any case and in TC or SC.
+// Overlapping matches are resolved leftmost-longest, and only the first occurrence of a text is kept as
+// evidence, as written in s
+func extract(field string, s string, kinds []model.TagKind) []model.TagMatch {
+	a := dict().automaton
+	original := []rune(s)
+	text := []rune(languages.Fold(s))
+
+	hits := []hit{}
+	for _, h := range a.scan(text) {
+		if slices.Contains(kinds, a.patterns[h.pattern].kind) && a.accepts(original, text, h) {
+			hits = append(hits, h)
+		}
+	}
+
+	matches := []model.TagMatch{}
+	seen := map[string]bool{}
+	for _, h := range leftmostLongest(hits) {
+		p := a.patterns[h.pattern]
+		key := string(p.kind) + ":" + string(p.text) + ":" + strings.Join(p.tags, ",")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		matches = append(matches, model.TagMatch{
+			Kind:   p.kind,
+			Field:  field,
+			Text:   string(original[h.start:h.end]),
+			Offset: h.start,
+			Tags:   p.tags,
+			Alias:  p.alias,
+		})
+	}
+	return matches
+}
+
+// TagsOf returns the distinct tags of the given kind resolved by matches. Locations are kept at the
+// finest level found
+func TagsOf(matches []model.TagMatch, kind model.TagKind) []string {
+	output := []string{}
+	for _, m := range matches {
+		if m.Kind == kind {
+			output = append(output, m.Tags...)
+		}
+	}
+	output = collection.RemoveListDuplicates[string](output)
+	if kind == model.TagKindLocation {
+		output = Finest(output)
+	}
+	return output
+}
+
+// Explain renders a match with TC names, e.g. "雪梨 → 悉尼"
+func Explain(m model.TagMatch) string {
+	if !m.Alias {
+		return m.Text
+	}
+	names := collection.Map(m.Tags, func(tag string) string {
+		if m.Kind == model.TagKindLocation {
+			return PlaceName(tag, languages.TC)
+		}
+		return AirlineName(tag, languages.TC)
+	})
+	return m.Text + " → " + strings.Join(names, ", ")
+}
+
+func sortHits(hits []hit) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].start != hits[j].start {
+			return hits[i].start < hits[j].start
+		}
+		if hits[i].end != hits[j].end {
+			return hits[i].end > hits[j].end
+		}
+		return hits[i].pattern < hits[j].pattern
+	})
+}
@@ -0,0 +1,48 @@
+package tags
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+)
+
+// goldenCase is a post with the tags it is expected to get, see testdata/golden.json
+type goldenCase struct {
+	Title     string   `json:"title"`
+	Summary   string   `json:"summary,omitempty"`
+	Locations []string `json:"locations"`
+	Airlines  []string `json:"airlines"`
+}
+
+// TestGolden tags the golden corpus with the dictionaries of the Go tables
+func TestGolden(t *testing.T) {
+	b, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []goldenCase{}
+	if err = json.Unmarshal(b, &cases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			matches := append(Extract(model.FieldTitle, c.Title), Extract(model.FieldSummary, c.Summary)...)
+			locations := TagsOf(matches, model.TagKindLocation)
+			airlines := TagsOf(matches, model.TagKindAirline)
+			if !sameTags(locations, c.Locations) || !sameTags(airlines, c.Airlines) {
+				t.Errorf("locations %v (want %v), airlines %v (want %v)", locations, c.Locations, airlines, c.Airlines)
+			}
+		})
+	}
+}
+
+func sameTags(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
@@ -0,0 +1,134 @@
+package tags
+
+import (
+	"sort"
+
+	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+)
+
+type (
+	// Alliance values are also the codes of the alliance groups
+	Alliance  string
+	GroupKind string
+
+	// AirlineGroupWithLabel is a group of airlines that can be selected as a whole, with its members
+	AirlineGroupWithLabel struct {
+		Label    string              `json:"label"`
+		Value    string              `json:"value"`
+		Kind     GroupKind           `json:"kind"`
+		Airlines []AirlinesWithLabel `json:"airlines"`
+	}
+
+	airlineGroup struct {
+		code    string
+		names   map[languages.Lang]string
+		kind    GroupKind
+		members []string
+	}
+)
+
+const (
+	AllianceOneworld Alliance = "ONEWORLD"
+	AllianceStar     Alliance = "STAR_ALLIANCE"
+	AllianceSkyTeam  Alliance = "SKYTEAM"
+
+	GroupLowCost     = "LOW_COST"
+	GroupFullService = "FULL_SERVICE"
+	// followed by the ISO 3166 code of the home country, e.g. HOME_JP
+	GroupHomePrefix = "HOME_"
+
+	GroupKindAlliance     GroupKind = "alliance"
+	GroupKindCarrierModel GroupKind = "carrier_model"
+	GroupKindHomeCountry  GroupKind = "home_country"
+)
+
+var Alliances = []Alliance{AllianceOneworld, AllianceStar, AllianceSkyTeam}
+
+var allianceNames = map[Alliance]map[languages.Lang]string{
+	AllianceOneworld: names("oneworld", "寰宇一家"),
+	AllianceStar:     names("Star Alliance", "星空聯盟"),
+	AllianceSkyTeam:  names("SkyTeam", "天合聯盟"),
+}
+
+// home countries of airlines that are not destinations of the taxonomy
+var homeCountryNames = map[string]map[languages.Lang]string{
+	"HK": names("Hong Kong", "香港"),
+	"QA": names("Qatar", "卡塔爾"),
+	"SA": names("Saudi Arabia", "沙特阿拉伯"),
+}
+
+// groups the airlines of the dictionary by alliance, carrier model and home country. Groups without
+// members are left out
+func (d *dictionary) groupAirlines() {
+	add := func(code string, names map[languages.Lang]string, kind GroupKind, member func(Airline) bool) {
+		group := &airlineGroup{code: code, names: names, kind: kind}
+		for _, airline := range d.airlines {
+			if member(airline) {
+				group.members = append(group.members, airline.Code)
+			}
+		}
+		if len(group.members) == 0 {
+			return
+		}
+		d.airlineGroups = append(d.airlineGroups, group)
+		d.groupIndex[code] = group
+		// airlines keep precedence over groups of the same name
+		for _, key := range []string{code, names[languages.TC], names[languages.EN]} {
+			if _, taken := d.airlineLookup[languages.Fold(key)]; !taken {
+				d.airlineLookup[languages.Fold(key)] = code
+			}
+		}
+	}
+
+	for _, alliance := range Alliances {
+		add(string(alliance), allianceNames[alliance], GroupKindAlliance, func(a Airline) bool { return a.Alliance == alliance })
+	}
+	add(GroupLowCost, names("Low-cost carriers", "廉航"), GroupKindCarrierModel, func(a Airline) bool { return a.LowCost })
+	add(GroupFullService, names("Full-service carriers", "傳統航空公司"), GroupKindCarrierModel, func(a Airline) bool { return !a.LowCost })
+
+	countries := collection.RemoveListDuplicates[string](collection.Map(d.airlines, func(a Airline) string { return a.Country }))
+	sort.Strings(countries)
+	for _, country := range countries {
+		if country == "" {
+			continue
+		}
+		countryNames, ok := homeCountryNames[country]
+		if node, found := d.placeIndex[country]; found {
+			countryNames, ok = node.names, true
+		}
+		if !ok {
+			countryNames = names(country, country)
+		}
+		groupNames := names("Carriers based in "+countryNames[languages.EN], countryNames[languages.TC]+"籍航空公司")
+		add(GroupHomePrefix+country, groupNames, GroupKindHomeCountry, func(a Airline) bool { return a.Country == country })
+	}
+}
+
+// ExpandAirlines replaces airline groups with the airlines in them, e.g. for subscriptions and filters
+func ExpandAirlines(codes []string) []string {
+	d := dict()
+	output := []string{}
+	for _, code := range codes {
+		if group, ok := d.groupIndex[code]; ok {
+			output = append(output, group.members...)
+		} else {
+			output = append(output, code)
+		}
+	}
+	return collection.RemoveListDuplicates[string](output)
+}
+
+// AirlineGroupsWithLabels lists the groups that can be subscribed to, alliances first
+func AirlineGroupsWithLabels(lang languages.Lang) []AirlineGroupWithLabel {
+	output := []AirlineGroupWithLabel{}
+	for _, group := range dict().airlineGroups {
+		output = append(output, AirlineGroupWithLabel{
+			Label:    label(group.names, lang),
+			Value:    group.code,
+			Kind:     group.kind,
+			Airlines: EnrichAirlinesWithLabels(group.members, lang),
+		})
+	}
+	return output
+}
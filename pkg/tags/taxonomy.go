@@ -0,0 +1,401 @@
+package tags
+
+import (
+	"strings"
+
+	model "github.com/jeffyfung/flight-info-agg/models"
+	"github.com/jeffyfung/flight-info-agg/pkg/collection"
+	"github.com/jeffyfung/flight-info-agg/pkg/languages"
+)
+
+type (
+	Level string
+
+	// Place is a node of the destination taxonomy. Its level is given by its depth: regions contain
+	// countries, which contain cities, which contain airports.
+	// Code is the tag value: ISO 3166 codes for countries, IATA codes for cities and airports (ISO 3166-2
+	// for areas without one, e.g. JP-01 for Hokkaido) and upper-case names for regions
+	Place struct {
+		Code     string
+		Names    map[languages.Lang]string
+		Aliases  []string
+		Rules    []model.ContextRule
+		Children []Place
+	}
+
+	placeNode struct {
+		code     string
+		names    map[languages.Lang]string
+		aliases  []string
+		level    Level
+		parent   string
+		children []string
+	}
+)
+
+const (
+	LevelRegion  Level = "region"
+	LevelCountry Level = "country"
+	LevelCity    Level = "city"
+	LevelAirport Level = "airport"
+)
+
+var levels = []Level{LevelRegion, LevelCountry, LevelCity, LevelAirport}
+
+func names(en string, tc string) map[languages.Lang]string {
+	return map[languages.Lang]string{languages.EN: en, languages.TC: tc}
+}
+
+// for places named differently in SC, not only written differently
+func namesSC(en string, tc string, sc string) map[languages.Lang]string {
+	return map[languages.Lang]string{languages.EN: en, languages.TC: tc, languages.SC: sc}
+}
+
+// localName returns the name in lang. SC names default to the TC name converted
+func localName(names map[languages.Lang]string, lang languages.Lang) string {
+	if name := names[lang]; name != "" {
+		return name
+	}
+	if lang == languages.SC {
+		return languages.ToSC(names[languages.TC])
+	}
+	return names[languages.TC]
+}
+
+// label is the name in lang followed by the EN name, e.g. "東京 Tokyo", or only the EN name for EN
+func label(names map[languages.Lang]string, lang languages.Lang) string {
+	if lang == languages.EN {
+		return names[languages.EN]
+	}
+	return localName(names, lang) + " " + names[languages.EN]
+}
+
+var Taxonomy = []Place{
+	{Code: "EAST_ASIA", Names: names("East Asia", "東亞"), Children: []Place{
+		{Code: "JP", Names: names("Japan", "日本"), Children: []Place{
+			{Code: "TYO", Names: names("Tokyo", "東京"), Children: []Place{
+				{Code: "NRT", Names: names("Narita", "成田")},
+				{Code: "HND", Names: names("Haneda", "羽田")},
+			}},
+			{Code: "OSA", Names: names("Osaka", "大阪"), Children: []Place{
+				{Code: "KIX", Names: names("Kansai", "關西")},
+			}},
+			{Code: "NGO", Names: names("Nagoya", "名古屋")},
+			{Code: "FUK", Names: names("Fukuoka", "福岡")},
+			{Code: "TAK", Names: names("Takamatsu", "高松")},
+			{Code: "KOJ", Names: names("Kagoshima", "鹿兒島")},
+			{Code: "KMJ", Names: names("Kumamoto", "熊本")},
+			{Code: "OKA", Names: names("Okinawa", "沖繩")},
+			{Code: "SPK", Names: names("Sapporo", "札幌")},
+			{Code: "SDJ", Names: names("Sendai", "仙台")},
+			{Code: "HIJ", Names: names("Hiroshima", "廣島")},
+			{Code: "JP-01", Names: names("Hokkaido", "北海道")},
+		}},
+		{Code: "KR", Names: names("Korea", "韓國"), Children: []Place{
+			{Code: "SEL", Names: names("Seoul", "首爾"), Children: []Place{
+				{Code: "ICN", Names: names("Incheon", "仁川")},
+			}},
+			{Code: "PUS", Names: names("Busan", "釜山")},
+			{Code: "CJU", Names: names("Jeju", "濟州")},
+		}},
+		{Code: "TW", Names: names("Taiwan", "台灣"), Children: []Place{
+			{Code: "TPE", Names: names("Taipei", "台北"), Aliases: []string{"桃園"}},
+			{Code: "RMQ", Names: names("Taichung", "台中")},
+			{Code: "TNN", Names: names("Tainan", "台南")},
+			{Code: "KHH", Names: names("Kaohsiung", "高雄")},
+		}},
+		{Code: "CN", Names: names("China", "中國"), Children: []Place{
+			{Code: "BJS", Names: names("Beijing", "北京")},
+		}},
+		{Code: "MO", Names: names("Macau", "澳門")},
+		{Code: "MN", Names: names("Mongolia", "蒙古")},
+	}},
+	{Code: "SOUTHEAST_ASIA", Names: names("Southeast Asia", "東南亞"), Children: []Place{
+		{Code: "TH", Names: names("Thailand", "泰國"), Children: []Place{
+			{Code: "BKK", Names: names("Bangkok", "曼谷")},
+			{Code: "HKT", Names: namesSC("Phuket", "布吉", "普吉")},
+			{Code: "CNX", Names: names("Chiang Mai", "清邁")},
+		}},
+		{Code: "SG", Names: names("Singapore", "新加坡")},
+		{Code: "MY", Names: names("Malaysia", "馬來西亞"), Children: []Place{
+			{Code: "KUL", Names: names("Kuala Lumpur", "吉隆坡")},
+			{Code: "PEN", Names: names("Penang", "檳城")},
+		}},
+		{Code: "VN", Names: names("Vietnam", "越南"), Children: []Place{
+			{Code: "DAD", Names: names("Da Nang", "峴港")},
+			{Code: "SGN", Names: names("Ho Chi Minh City", "胡志明市")},
+			{Code: "NHA", Names: names("Nha Trang", "芽莊")},
+			{Code: "HAN", Names: names("Hanoi", "河內")},
+		}},
+		{Code: "PH", Names: names("Philippines", "菲律賓"), Children: []Place{
+			{Code: "MNL", Names: names("Manila", "馬尼拉")},
+			{Code: "CEB", Names: names("Cebu", "宿霧")},
+			{Code: "MPH", Names: names("Boracay", "長灘島")},
+		}},
+		{Code: "ID", Names: names("Indonesia", "印尼"), Children: []Place{
+			{Code: "JKT", Names: names("Jakarta", "雅加達")},
+			{Code: "DPS", Names: namesSC("Bali", "峇里島", "巴厘岛"), Aliases: []string{"巴里"}},
+		}},
+		{Code: "KH", Names: names("Cambodia", "柬埔寨")},
+		{Code: "LA", Names: names("Laos", "老撾")},
+		{Code: "MM", Names: names("Myanmar", "緬甸")},
+		{Code: "BN", Names: names("Brunei", "汶萊")},
+	}},
+	{Code: "SOUTH_ASIA", Names: names("South Asia", "南亞"), Children: []Place{
+		{Code: "IN", Names: names("India", "印度")},
+		{Code: "NP", Names: names("Nepal", "尼泊爾")},
+		{Code: "LK", Names: names("Sri Lanka", "斯里蘭卡")},
+		{Code: "MV", Names: names("Maldives", "馬爾代夫")},
+	}},
+	{Code: "CENTRAL_ASIA", Names: names("Central Asia", "中亞"), Children: []Place{
+		{Code: "KZ", Names: names("Kazakhstan", "哈薩克")},
+		{Code: "UZ", Names: names("Uzbekistan", "烏茲別克斯坦")},
+	}},
+	{Code: "MIDDLE_EAST", Names: names("Middle East", "中東"), Children: []Place{
+		{Code: "AE", Names: names("United Arab Emirates", "阿聯酋"), Children: []Place{
+			{Code: "DXB", Names: namesSC("Dubai", "杜拜", "迪拜")},
+		}},
+		{Code: "JO", Names: names("Jordan", "約旦")},
+		{Code: "TR", Names: names("Turkey", "土耳其"), Children: []Place{
+			{Code: "IST", Names: names("Istanbul", "伊斯坦堡")},
+		}},
+	}},
+	{Code: "EUROPE", Names: names("Europe", "歐洲"), Children: []Place{
+		{Code: "GB", Names: names("United Kingdom", "英國"), Children: []Place{
+			{Code: "LON", Names: names("London", "倫敦"), Children: []Place{
+				{Code: "LHR", Names: names("Heathrow", "希斯路")},
+			}},
+			{Code: "MAN", Names: names("Manchester", "曼徹斯特")},
+			{Code: "EDI", Names: names("Edinburgh", "愛丁堡")},
+		}},
+		{Code: "IE", Names: names("Ireland", "愛爾蘭"), Children: []Place{
+			{Code: "DUB", Names: names("Dublin", "都柏林")},
+		}},
+		{Code: "FR", Names: names("France", "法國"), Children: []Place{
+			{Code: "PAR", Names: names("Paris", "巴黎")},
+		}},
+		{Code: "DE", Names: names("Germany", "德國"), Children: []Place{
+			{Code: "MUC", Names: names("Munich", "慕尼黑")},
+			{Code: "BER", Names: names("Berlin", "柏林")},
+			{Code: "HAM", Names: names("Hamburg", "漢堡")},
+			{Code: "FRA", Names: names("Frankfurt", "法蘭克福")},
+			{Code: "CGN", Names: names("Cologne", "科隆")},
+			{Code: "DUS", Names: names("Düsseldorf", "杜塞爾多夫")},
+			{Code: "STR", Names: names("Stuttgart", "斯圖加特")},
+			{Code: "HAJ", Names: names("Hanover", "漢諾威")},
+			{Code: "NUE", Names: names("Nuremberg", "紐倫堡")},
+		}},
+		{Code: "IT", Names: names("Italy", "意大利"), Children: []Place{
+			{Code: "ROM", Names: names("Rome", "羅馬")},
+			{Code: "MIL", Names: names("Milan", "米蘭")},
+			{Code: "FLR", Names: names("Florence", "佛羅倫斯")},
+			{Code: "VCE", Names: names("Venice", "威尼斯")},
+		}},
+		{Code: "ES", Names: names("Spain", "西班牙"), Children: []Place{
+			{Code: "BCN", Names: names("Barcelona", "巴塞隆拿")},
+			{Code: "MAD", Names: names("Madrid", "馬德里")},
+		}},
+		{Code: "PT", Names: names("Portugal", "葡萄牙"), Children: []Place{
+			{Code: "LIS", Names: names("Lisbon", "里斯本")},
+			{Code: "FNC", Names: names("Madeira", "馬德拉")},
+			{Code: "OPO", Names: names("Porto", "波爾圖")},
+		}},
+		{Code: "NL", Names: names("Netherlands", "荷蘭"), Children: []Place{
+			{Code: "AMS", Names: names("Amsterdam", "阿姆斯特丹")},
+			{Code: "RTM", Names: names("Rotterdam", "鹿特丹")},
+			{Code: "MST", Names: names("Maastricht", "馬斯垂克")},
+		}},
+		{Code: "BE", Names: names("Belgium", "比利時"), Children: []Place{
+			{Code: "BRU", Names: names("Brussels", "布魯塞爾")},
+		}},
+		{Code: "CH", Names: names("Switzerland", "瑞士"), Children: []Place{
+			{Code: "GVA", Names: names("Geneva", "日內瓦")},
+			{Code: "ZRH", Names: names("Zurich", "蘇黎世")},
+			{Code: "BSL", Names: names("Basel", "巴塞爾")},
+		}},
+		{Code: "AT", Names: names("Austria", "奧地利"), Children: []Place{
+			{Code: "VIE", Names: names("Vienna", "維也納")},
+			{Code: "SZG", Names: names("Salzburg", "薩爾茨堡")},
+		}},
+		{Code: "CZ", Names: names("Czech Republic", "捷克"), Children: []Place{
+			{Code: "PRG", Names: names("Prague", "布拉格")},
+		}},
+		{Code: "PL", Names: names("Poland", "波蘭"), Children: []Place{
+			{Code: "WAW", Names: names("Warsaw", "華沙")},
+			{Code: "KRK", Names: names("Krakow", "克拉科夫")},
+		}},
+		{Code: "HU", Names: names("Hungary", "匈牙利"), Children: []Place{
+			{Code: "BUD", Names: names("Budapest", "布達佩斯")},
+		}},
+		{Code: "GR", Names: names("Greece", "希臘"), Children: []Place{
+			{Code: "ATH", Names: names("Athens", "雅典")},
+		}},
+		{Code: "NO", Names: names("Norway", "挪威"), Children: []Place{
+			{Code: "OSL", Names: names("Oslo", "奧斯陸")},
+		}},
+		{Code: "SE", Names: names("Sweden", "瑞典"), Children: []Place{
+			{Code: "STO", Names: names("Stockholm", "斯德哥爾摩")},
+		}},
+		{Code: "FI", Names: names("Finland", "芬蘭"), Children: []Place{
+			{Code: "HEL", Names: names("Helsinki", "赫爾辛基")},
+		}},
+		{Code: "IS", Names: names("Iceland", "冰島"), Children: []Place{
+			{Code: "REK", Names: names("Reykjavik", "雷克雅維克")},
+		}},
+		{Code: "RU", Names: names("Russia", "俄羅斯")},
+		{Code: "AZ", Names: names("Azerbaijan", "阿塞拜疆")},
+		{Code: "AM", Names: names("Armenia", "亞美尼亞")},
+	}},
+	{Code: "AFRICA", Names: names("Africa", "非洲"), Children: []Place{
+		{Code: "EG", Names: names("Egypt", "埃及"), Children: []Place{
+			{Code: "CAI", Names: names("Cairo", "開羅")},
+		}},
+		{Code: "ZA", Names: names("South Africa", "南非"), Children: []Place{
+			{Code: "CPT", Names: names("Cape Town", "開普敦")},
+			{Code: "JNB", Names: names("Johannesburg", "約翰內斯堡")},
+		}},
+	}},
+	{Code: "OCEANIA", Names: names("Oceania", "大洋洲"), Children: []Place{
+		{Code: "AU", Names: names("Australia", "澳洲"), Children: []Place{
+			{Code: "SYD", Names: names("Sydney", "悉尼"), Aliases: []string{"雪梨"}},
+			{Code: "PER", Names: names("Perth", "珀斯")},
+			{Code: "MEL", Names: names("Melbourne", "墨爾本")},
+			{Code: "BNE", Names: names("Brisbane", "布里斯班")},
+			{Code: "ADL", Names: names("Adelaide", "阿德雷德")},
+			{Code: "DRW", Names: names("Darwin", "達爾文")},
+			{Code: "CBR", Names: names("Canberra", "堪培拉")},
+			{Code: "HBA", Names: names("Hobart", "霍巴特")},
+		}},
+		{Code: "NZ", Names: names("New Zealand", "紐西蘭"), Aliases: []string{"新西蘭"}, Children: []Place{
+			{Code: "AKL", Names: names("Auckland", "奧克蘭")},
+			{Code: "WLG", Names: names("Wellington", "惠靈頓")},
+			{Code: "CHC", Names: names("Christchurch", "基督城")},
+		}},
+		{Code: "FJ", Names: names("Fiji", "斐濟")},
+		{Code: "PG", Names: names("Papua New Guinea", "巴布亞新畿內亞"), Children: []Place{
+			{Code: "POM", Names: names("Port Moresby", "莫爾斯比港")},
+		}},
+	}},
+	{Code: "NORTH_AMERICA", Names: names("North America", "北美洲"), Children: []Place{
+		{Code: "US", Names: names("United States", "美國"), Children: []Place{
+			{Code: "NYC", Names: names("New York", "紐約")},
+			{Code: "LAX", Names: names("Los Angeles", "洛杉磯")},
+			{Code: "SFO", Names: namesSC("San Francisco", "三藩市", "旧金山")},
+			{Code: "CHI", Names: names("Chicago", "芝加哥")},
+			{Code: "SEA", Names: names("Seattle", "西雅圖")},
+			{Code: "BOS", Names: names("Boston", "波士頓")},
+			{Code: "WAS", Names: names("Washington", "華盛頓")},
+			{Code: "ORL", Names: names("Orlando", "奧蘭多")},
+			{Code: "MIA", Names: names("Miami", "邁阿密")},
+			{Code: "LAS", Names: names("Las Vegas", "拉斯維加斯")},
+			{Code: "US-HI", Names: names("Hawaii", "夏威夷")},
+		}},
+		{Code: "CA", Names: names("Canada", "加拿大"), Children: []Place{
+			{Code: "YTO", Names: names("Toronto", "多倫多")},
+			{Code: "YVR", Names: names("Vancouver", "溫哥華")},
+			{Code: "YMQ", Names: names("Montreal", "蒙特婁")},
+			{Code: "YYC", Names: names("Calgary", "卡爾加里")},
+			{Code: "YEA", Names: names("Edmonton", "埃德蒙頓")},
+			{Code: "YOW", Names: names("Ottawa", "渥太華")},
+			{Code: "YQB", Names: names("Quebec City", "魁北克")},
+			{Code: "YWG", Names: names("Winnipeg", "溫尼伯")},
+			{Code: "YYJ", Names: names("Victoria", "維多利亞")},
+		}},
+		{Code: "MX", Names: names("Mexico", "墨西哥")},
+		{Code: "BM", Names: names("Bermuda", "百慕達")},
+	}},
+	{Code: "LATIN_AMERICA", Names: names("Latin America", "中南美洲"), Children: []Place{
+		{Code: "BR", Names: names("Brazil", "巴西")},
+		{Code: "AR", Names: names("Argentina", "阿根廷")},
+		{Code: "CL", Names: names("Chile", "智利")},
+		{Code: "PE", Names: names("Peru", "秘魯")},
+		{Code: "CO", Names: names("Colombia", "哥倫比亞")},
+		{Code: "EC", Names: names("Ecuador", "厄瓜多爾")},
+		{Code: "PA", Names: names("Panama", "巴拿馬")},
+		{Code: "CR", Names: names("Costa Rica", "哥斯達黎加")},
+		{Code: "CU", Names: names("Cuba", "古巴")},
+		{Code: "DO", Names: names("Dominican Republic", "多米尼加")},
+		{Code: "PR", Names: names("Puerto Rico", "波多黎各")},
+		{Code: "JM", Names: names("Jamaica", "牙買加")},
+		{Code: "TT", Names: names("Trinidad and Tobago", "千里達及托巴哥")},
+		{Code: "BB", Names: names("Barbados", "巴貝多")},
+		{Code: "BS", Names: names("Bahamas", "巴哈馬")},
+	}},
+}
+
+// PlaceCodes returns the tag values of every place in the taxonomy
+func PlaceCodes() []string {
+	return collection.Map(dict().places, func(node *placeNode) string {
+		return node.code
+	})
+}
+
+// LocationCode resolves a code, a TC or EN name or an alias to the code of the place
+func LocationCode(s string) (string, bool) {
+	code, ok := dict().placeLookup[languages.Fold(strings.TrimSpace(s))]
+	return code, ok
+}
+
+// PlaceName returns the name of the place in the given language, or the code if the place is unknown
+func PlaceName(code string, lang languages.Lang) string {
+	if node, ok := dict().placeIndex[code]; ok {
+		return localName(node.names, lang)
+	}
+	return code
+}
+
+// Descendants returns the place and every place under it
+func Descendants(code string) []string {
+	d := dict()
+	output := []string{}
+	var walk func(code string)
+	walk = func(code string) {
+		output = append(output, code)
+		if node, ok := d.placeIndex[code]; ok {
+			for _, child := range node.children {
+				walk(child)
+			}
+		}
+	}
+	walk(code)
+	return output
+}
+
+// WithDescendants expands a subscription so that it also matches posts tagged at a finer level
+func WithDescendants(codes []string) []string {
+	output := []string{}
+	for _, code := range codes {
+		output = append(output, Descendants(code)...)
+	}
+	return collection.RemoveListDuplicates[string](output)
+}
+
+// Ancestors returns the places containing the given place, closest first
+func Ancestors(code string) []string {
+	d := dict()
+	output := []string{}
+	node, ok := d.placeIndex[code]
+	for ok && node.parent != "" {
+		output = append(output, node.parent)
+		node, ok = d.placeIndex[node.parent]
+	}
+	return output
+}
+
+// Finest drops places that contain another place in the list, e.g. JP is dropped when TYO is present
+func Finest(codes []string) []string {
+	covered := map[string]struct{}{}
+	for _, code := range codes {
+		for _, ancestor := range Ancestors(code) {
+			covered[ancestor] = struct{}{}
+		}
+	}
+
+	output := []string{}
+	for _, code := range codes {
+		if _, ok := covered[code]; !ok {
+			output = append(output, code)
+		}
+	}
+	return output
+}